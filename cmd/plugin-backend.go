@@ -2,16 +2,24 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	server "github.com/openshift/distributed-tracing-console-plugin/pkg"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfig(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	portArg := flag.Int("port", 0, "server port to listen on (default: 9443)")
+	metricsPortArg := flag.Int("metrics-port", 0, "port to serve /metrics on, if different from the server port (default: server port)")
 	certArg := flag.String("cert", "", "cert file path to enable TLS (disabled by default)")
 	keyArg := flag.String("key", "", "private key file path to enable TLS (disabled by default)")
 	tlsMinVersionArg := flag.String("tls-min-version", "", "Minimum TLS version supported. Value must match version names from https://golang.org/pkg/crypto/tls/#pkg-constants (default: VersionTLS12).")
@@ -20,11 +28,20 @@ func main() {
 	staticPathArg := flag.String("static-path", "", "static files path to serve frontend (default: './web/dist')")
 	configPathArg := flag.String("config-path", "", "config files path (default: './web/dist')")
 	pluginConfigArg := flag.String("plugin-config-path", "", "plugin yaml configuration")
+	shutdownDelayArg := flag.Duration("shutdown-delay", 0, "time between failing the readiness checks and closing the listeners on shutdown (default: 5s)")
+	shutdownTimeoutArg := flag.Duration("shutdown-timeout", 0, "maximum time to wait for in-flight requests on shutdown (default: 20s)")
 	flag.Parse()
 
+	// a zero duration is a valid value, therefore durations are only taken from flags which are set
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
 	var log = logrus.WithField("module", "main")
 
 	port := mergeEnvValueInt("PORT", *portArg, 9443)
+	metricsPort := mergeEnvValueInt("METRICS_PORT", *metricsPortArg, 0)
 	cert := mergeEnvValue("CERT_FILE_PATH", *certArg, "")
 	key := mergeEnvValue("PRIVATE_KEY_FILE_PATH", *keyArg, "")
 	tlsMinVersion := mergeEnvValue("TLS_MIN_VERSION", *tlsMinVersionArg, "")
@@ -33,6 +50,8 @@ func main() {
 	staticPath := mergeEnvValue("DISTRIBUTED_TRACING_CONSOLE_PLUGIN_STATIC_PATH", *staticPathArg, "./web/dist")
 	configPath := mergeEnvValue("DISTRIBUTED_TRACING_CONSOLE_PLUGIN_MANIFEST_CONFIG_PATH", *configPathArg, "./web/dist")
 	pluginConfigPath := mergeEnvValue("DISTRIBUTED_TRACING_CONSOLE_PLUGIN_CONFIG_PATH", *pluginConfigArg, "/etc/plugin/config.yaml")
+	shutdownDelay := mergeEnvValueDuration("SHUTDOWN_DELAY", *shutdownDelayArg, setFlags["shutdown-delay"], 5*time.Second)
+	shutdownTimeout := mergeEnvValueDuration("SHUTDOWN_TIMEOUT", *shutdownTimeoutArg, setFlags["shutdown-timeout"], 20*time.Second)
 
 	featuresList := strings.Fields(strings.Join(strings.Split(strings.ToLower(features), ","), " "))
 
@@ -45,6 +64,7 @@ func main() {
 
 	server.Start(&server.Config{
 		Port:             port,
+		MetricsPort:      metricsPort,
 		CertFile:         cert,
 		PrivateKeyFile:   key,
 		TLSMinVersion:    tlsMinVersion,
@@ -53,9 +73,35 @@ func main() {
 		StaticPath:       staticPath,
 		ConfigPath:       configPath,
 		PluginConfigPath: pluginConfigPath,
+		ShutdownDelay:    shutdownDelay,
+		ShutdownTimeout:  shutdownTimeout,
 	})
 }
 
+// validateConfig implements the validate-config subcommand, which checks plugin configuration files
+// and prints all errors with their position.
+func validateConfig(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: plugin-backend validate-config <file>...")
+		return 2
+	}
+
+	exitCode := 0
+	for _, path := range args {
+		err := server.ValidatePluginConfigFile(path)
+		if err == nil {
+			fmt.Fprintf(stdout, "%s: valid\n", path)
+			continue
+		}
+
+		exitCode = 1
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(stderr, "%s: %s\n", path, line)
+		}
+	}
+	return exitCode
+}
+
 func mergeEnvValue(key string, arg string, defaultValue string) string {
 	if arg != "" {
 		return arg
@@ -86,9 +132,22 @@ func mergeEnvValueInt(key string, arg int, defaultValue int) int {
 	envValue := os.Getenv(key)
 
 	num, err := strconv.Atoi(envValue)
-	if err != nil && num != 0 {
+	if err == nil && num != 0 {
 		return num
 	}
 
 	return defaultValue
 }
+
+func mergeEnvValueDuration(key string, arg time.Duration, argSet bool, defaultValue time.Duration) time.Duration {
+	if argSet {
+		return arg
+	}
+
+	duration, err := time.ParseDuration(os.Getenv(key))
+	if err == nil {
+		return duration
+	}
+
+	return defaultValue
+}
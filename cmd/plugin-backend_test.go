@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -49,3 +52,54 @@ func TestMergeEnvValueSlice(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeEnvValueInt(t *testing.T) {
+	require.Equal(t, 9443, mergeEnvValueInt("TEST_METRICS_PORT", 0, 9443))
+	require.Equal(t, 8080, mergeEnvValueInt("TEST_METRICS_PORT", 8080, 9443))
+
+	t.Setenv("TEST_METRICS_PORT", "9090")
+	require.Equal(t, 9090, mergeEnvValueInt("TEST_METRICS_PORT", 0, 0))
+	require.Equal(t, 8080, mergeEnvValueInt("TEST_METRICS_PORT", 8080, 0))
+
+	t.Setenv("TEST_METRICS_PORT", "invalid")
+	require.Equal(t, 0, mergeEnvValueInt("TEST_METRICS_PORT", 0, 0))
+}
+
+func TestMergeEnvValueDuration(t *testing.T) {
+	require.Equal(t, 3*time.Second, mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 3*time.Second, true, 5*time.Second))
+	require.Equal(t, 5*time.Second, mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 0, false, 5*time.Second))
+	// a flag set to zero disables the delay
+	require.Equal(t, time.Duration(0), mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 0, true, 5*time.Second))
+
+	t.Setenv("TEST_SHUTDOWN_DELAY", "10s")
+	require.Equal(t, 10*time.Second, mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 0, false, 5*time.Second))
+	require.Equal(t, 3*time.Second, mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 3*time.Second, true, 5*time.Second))
+	require.Equal(t, time.Duration(0), mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 0, true, 5*time.Second))
+
+	t.Setenv("TEST_SHUTDOWN_DELAY", "0s")
+	require.Equal(t, time.Duration(0), mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 0, false, 5*time.Second))
+
+	t.Setenv("TEST_SHUTDOWN_DELAY", "invalid")
+	require.Equal(t, 5*time.Second, mergeEnvValueDuration("TEST_SHUTDOWN_DELAY", 0, false, 5*time.Second))
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	require.NoError(t, os.WriteFile(valid, []byte("timeout: 30s\n"), 0600))
+	invalid := filepath.Join(dir, "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalid, []byte("timeout: 30s\nlogsLimit: 100\nsingleTenantInstances: 1s\n"), 0600))
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, validateConfig([]string{valid}, &stdout, &stderr))
+	require.Equal(t, valid+": valid\n", stdout.String())
+	require.Empty(t, stderr.String())
+
+	stdout.Reset()
+	require.Equal(t, 1, validateConfig([]string{invalid}, &stdout, &stderr))
+	require.Empty(t, stdout.String())
+	require.Equal(t, invalid+": line 2, column 1: unknown field 'logsLimit'\n"+
+		invalid+": line 3, column 24: singleTenantInstances: cannot unmarshal !!str `1s` into bool\n", stderr.String())
+
+	require.Equal(t, 2, validateConfig(nil, &stdout, &stderr))
+}
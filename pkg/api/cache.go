@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	tempoCacheResyncPeriod = 10 * time.Minute
+	// maximum time a request waits for the initial list of Tempo resources
+	tempoCacheSyncTimeout = 10 * time.Second
+)
+
+// TempoCache keeps a watch-based, in-memory copy of the TempoStack and TempoMonolithic
+// resources of the cluster, shared by the list handler and the proxy.
+type TempoCache struct {
+	informers         []*tempoInformer
+	staticDatasources []StaticDatasource
+}
+
+type TempoCacheOptions struct {
+	// SingleTenantInstances enables Tempo instances without multi-tenancy.
+	SingleTenantInstances bool
+	// StaticDatasources are listed after the Tempo resources of the cluster.
+	StaticDatasources []StaticDatasource
+}
+
+type tempoInformer struct {
+	gvr                   schema.GroupVersionResource
+	informer              cache.SharedIndexInformer
+	singleTenantInstances bool
+
+	mu      sync.RWMutex
+	listErr error
+}
+
+func NewTempoCache(k8sclient dynamic.Interface, opts TempoCacheOptions) *TempoCache {
+	return &TempoCache{
+		// the order of the informers determines the order of the list results
+		informers: []*tempoInformer{
+			newTempoInformer(k8sclient, tempostackGVR, opts),
+			newTempoInformer(k8sclient, tempomonolithicGVR, opts),
+		},
+		staticDatasources: validStaticDatasources(opts.StaticDatasources),
+	}
+}
+
+// validStaticDatasources returns the valid datasources, and logs the invalid ones.
+func validStaticDatasources(datasources []StaticDatasource) []StaticDatasource {
+	valid := []StaticDatasource{}
+	names := map[string]bool{}
+	for _, ds := range datasources {
+		if err := ds.Validate(); err != nil {
+			log.Error(err)
+			continue
+		}
+		if names[ds.Name] {
+			log.Errorf("duplicate datasource name '%s'", ds.Name)
+			continue
+		}
+		names[ds.Name] = true
+		valid = append(valid, ds)
+	}
+	return valid
+}
+
+func newTempoInformer(k8sclient dynamic.Interface, gvr schema.GroupVersionResource, opts TempoCacheOptions) *tempoInformer {
+	ti := &tempoInformer{gvr: gvr, singleTenantInstances: opts.SingleTenantInstances}
+	lw := &cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			start := time.Now()
+			list, err := k8sclient.Resource(gvr).List(ctx, options)
+			metrics.KubernetesListDuration.WithLabelValues(gvr.Resource).Observe(time.Since(start).Seconds())
+			if err != nil {
+				metrics.KubernetesListErrorsTotal.WithLabelValues(gvr.Resource).Inc()
+			}
+			// keep the last list error, to report it to clients while the cache is not synced
+			ti.setListError(err)
+			return list, err
+		},
+		WatchFuncWithContext: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return k8sclient.Resource(gvr).Watch(ctx, options)
+		},
+	}
+	ti.informer = cache.NewSharedIndexInformer(cache.ToListWatcherWithWatchListSemantics(lw, k8sclient), &unstructured.Unstructured{}, tempoCacheResyncPeriod, cache.Indexers{})
+	return ti
+}
+
+// Start runs the informers until the context is cancelled. It does not wait for the initial sync.
+func (c *TempoCache) Start(ctx context.Context) {
+	for _, ti := range c.informers {
+		go ti.informer.RunWithContext(ctx)
+	}
+}
+
+// ListTempoResources returns all Tempo resources of the cluster, ordered by kind, namespace and name.
+func (c *TempoCache) ListTempoResources(ctx context.Context) ([]TempoResource, error) {
+	resources := []TempoResource{}
+	for _, ti := range c.informers {
+		if err := ti.waitForSync(ctx); err != nil {
+			return nil, err
+		}
+		resources = append(resources, ti.list()...)
+	}
+	for _, ds := range c.staticDatasources {
+		resources = append(resources, ds.tempoResource())
+	}
+	return resources, nil
+}
+
+// GetTempoResource returns the Tempo resource with the given namespace and name.
+// The boolean return value is false if no valid Tempo resource was found.
+func (c *TempoCache) GetTempoResource(ctx context.Context, namespace string, name string) (TempoResource, bool, error) {
+	if namespace == StaticDatasourceNamespace {
+		ds, ok := c.GetStaticDatasource(name)
+		if !ok {
+			return TempoResource{}, false, nil
+		}
+		return ds.tempoResource(), true, nil
+	}
+
+	for _, ti := range c.informers {
+		if err := ti.waitForSync(ctx); err != nil {
+			return TempoResource{}, false, err
+		}
+
+		obj, exists, err := ti.informer.GetStore().GetByKey(fmt.Sprintf("%s/%s", namespace, name))
+		if err != nil {
+			return TempoResource{}, false, err
+		}
+		if !exists {
+			continue
+		}
+
+		resource, ok := tempoResourceFromCR(obj.(*unstructured.Unstructured), ti.singleTenantInstances)
+		if ok {
+			return resource, true, nil
+		}
+	}
+	return TempoResource{}, false, nil
+}
+
+// GetStaticDatasource returns the static datasource with the given name.
+func (c *TempoCache) GetStaticDatasource(name string) (StaticDatasource, bool) {
+	for _, ds := range c.staticDatasources {
+		if ds.Name == name {
+			return ds, true
+		}
+	}
+	return StaticDatasource{}, false
+}
+
+// AddChangeHandler registers a function which is called with the namespace and name of a Tempo resource
+// whenever it is deleted, or updated in a way that changes the resulting TempoResource.
+func (c *TempoCache) AddChangeHandler(handler func(namespace string, name string)) {
+	for _, ti := range c.informers {
+		_, err := ti.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldResource, ok := oldObj.(*unstructured.Unstructured)
+				if !ok {
+					return
+				}
+				newResource, ok := newObj.(*unstructured.Unstructured)
+				if !ok {
+					return
+				}
+				// periodic resyncs deliver updates without changes
+				if oldResource.GetResourceVersion() == newResource.GetResourceVersion() {
+					return
+				}
+
+				oldTempo, oldOk := tempoResourceFromCR(oldResource, ti.singleTenantInstances)
+				newTempo, newOk := tempoResourceFromCR(newResource, ti.singleTenantInstances)
+				if oldOk != newOk || !reflect.DeepEqual(oldTempo, newTempo) {
+					handler(newResource.GetNamespace(), newResource.GetName())
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if resource, ok := obj.(*unstructured.Unstructured); ok {
+					handler(resource.GetNamespace(), resource.GetName())
+				}
+			},
+		})
+		if err != nil {
+			log.WithError(err).Errorf("cannot register event handler for %s", ti.gvr.String())
+		}
+	}
+}
+
+func (ti *tempoInformer) setListError(err error) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.listErr = err
+}
+
+func (ti *tempoInformer) listError() error {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	return ti.listErr
+}
+
+func (ti *tempoInformer) waitForSync(ctx context.Context) error {
+	if ti.informer.HasSynced() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tempoCacheSyncTimeout)
+	defer cancel()
+
+	err := wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		if ti.informer.HasSynced() {
+			return true, nil
+		}
+		// fail fast if the initial list failed, e.g. if the CRD is not installed
+		return false, ti.listError()
+	})
+	if err != nil {
+		return fmt.Errorf("cannot list %s resource: %w", ti.gvr.String(), err)
+	}
+	return nil
+}
+
+func (ti *tempoInformer) list() []TempoResource {
+	resources := []TempoResource{}
+	for _, obj := range ti.informer.GetStore().List() {
+		resource, ok := tempoResourceFromCR(obj.(*unstructured.Unstructured), ti.singleTenantInstances)
+		if ok {
+			resources = append(resources, resource)
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Namespace != resources[j].Namespace {
+			return resources[i].Namespace < resources[j].Namespace
+		}
+		return resources[i].Name < resources[j].Name
+	})
+	return resources
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTempoStack(namespace string, name string, tenants ...string) *unstructured.Unstructured {
+	authentication := []interface{}{}
+	for _, tenant := range tenants {
+		authentication = append(authentication, map[string]interface{}{"tenantName": tenant, "tenantId": tenant})
+	}
+
+	spec := map[string]interface{}{}
+	if len(tenants) > 0 {
+		spec["tenants"] = map[string]interface{}{
+			"mode":           "openshift",
+			"authentication": authentication,
+		}
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "tempo.grafana.com/v1alpha1",
+		"kind":       "TempoStack",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"spec":       spec,
+	}}
+}
+
+func newTempoMonolithic(namespace string, name string, tenants ...string) *unstructured.Unstructured {
+	authentication := []interface{}{}
+	for _, tenant := range tenants {
+		authentication = append(authentication, map[string]interface{}{"tenantName": tenant, "tenantId": tenant})
+	}
+
+	spec := map[string]interface{}{}
+	if len(tenants) > 0 {
+		spec["multitenancy"] = map[string]interface{}{
+			"enabled":        true,
+			"mode":           "openshift",
+			"authentication": authentication,
+		}
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "tempo.grafana.com/v1alpha1",
+		"kind":       "TempoMonolithic",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"spec":       spec,
+	}}
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		tempostackGVR:      "TempoStackList",
+		tempomonolithicGVR: "TempoMonolithicList",
+	}, objects...)
+}
+
+func startTempoCache(t *testing.T, tempoCache *TempoCache) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	tempoCache.Start(ctx)
+}
+
+func TestTempoCacheList(t *testing.T) {
+	k8sclient := newFakeDynamicClient(
+		newTempoStack("ns2", "stack", "dev", "prod"),
+		newTempoStack("ns1", "stack", "dev"),
+		newTempoStack("ns1", "single-tenant-stack"),
+		newTempoMonolithic("ns1", "mono", "dev"),
+	)
+	tempoCache := NewTempoCache(k8sclient, TempoCacheOptions{})
+	startTempoCache(t, tempoCache)
+
+	resources, err := tempoCache.ListTempoResources(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []TempoResource{
+		{Kind: KindTempoStack, Namespace: "ns1", Name: "stack", Tenants: []string{"dev"}, TenancyMode: TenancyModeOpenShift},
+		{Kind: KindTempoStack, Namespace: "ns2", Name: "stack", Tenants: []string{"dev", "prod"}, TenancyMode: TenancyModeOpenShift},
+		{Kind: KindTempoMonolithic, Namespace: "ns1", Name: "mono", Tenants: []string{"dev"}, TenancyMode: TenancyModeOpenShift},
+	}, resources)
+
+	// new resources are picked up by the watch
+	_, err = k8sclient.Resource(tempomonolithicGVR).Namespace("ns3").Create(context.Background(), newTempoMonolithic("ns3", "mono", "dev"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, found, err := tempoCache.GetTempoResource(context.Background(), "ns3", "mono")
+		return err == nil && found
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestTempoCacheGet(t *testing.T) {
+	tempoCache := NewTempoCache(newFakeDynamicClient(
+		newTempoStack("ns1", "stack", "dev"),
+		newTempoStack("ns1", "single-tenant-stack"),
+	), TempoCacheOptions{})
+	startTempoCache(t, tempoCache)
+
+	tempo, found, err := tempoCache.GetTempoResource(context.Background(), "ns1", "stack")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, TempoResource{Kind: KindTempoStack, Namespace: "ns1", Name: "stack", Tenants: []string{"dev"}, TenancyMode: TenancyModeOpenShift}, tempo)
+
+	_, found, err = tempoCache.GetTempoResource(context.Background(), "ns1", "single-tenant-stack")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	_, found, err = tempoCache.GetTempoResource(context.Background(), "ns1", "unknown")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestTempoCacheSingleTenantInstances(t *testing.T) {
+	// multitenant instances without tenants are not single-tenant instances
+	stackWithoutTenants := newTempoStack("ns1", "stack-without-tenants", "dev")
+	require.NoError(t, unstructured.SetNestedSlice(stackWithoutTenants.Object, []interface{}{}, "spec", "tenants", "authentication"))
+	monoWithoutTenants := newTempoMonolithic("ns1", "mono-without-tenants", "dev")
+	require.NoError(t, unstructured.SetNestedSlice(monoWithoutTenants.Object, []interface{}{}, "spec", "multitenancy", "authentication"))
+
+	tempoCache := NewTempoCache(newFakeDynamicClient(
+		newTempoStack("ns1", "stack", "dev"),
+		newTempoStack("ns1", "single-tenant-stack"),
+		newTempoMonolithic("ns1", "single-tenant-mono"),
+		stackWithoutTenants,
+		monoWithoutTenants,
+	), TempoCacheOptions{SingleTenantInstances: true})
+	startTempoCache(t, tempoCache)
+
+	resources, err := tempoCache.ListTempoResources(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []TempoResource{
+		{Kind: KindTempoStack, Namespace: "ns1", Name: "single-tenant-stack", Tenants: []string{}, SingleTenant: true},
+		{Kind: KindTempoStack, Namespace: "ns1", Name: "stack", Tenants: []string{"dev"}, TenancyMode: TenancyModeOpenShift},
+		{Kind: KindTempoMonolithic, Namespace: "ns1", Name: "single-tenant-mono", Tenants: []string{}, SingleTenant: true},
+	}, resources)
+}
+
+func TestTempoCacheCRDNotFound(t *testing.T) {
+	k8sclient := newFakeDynamicClient()
+	k8sclient.PrependReactor("list", "tempostacks", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(tempostackGVR.GroupResource(), "")
+	})
+	tempoCache := NewTempoCache(k8sclient, TempoCacheOptions{})
+	startTempoCache(t, tempoCache)
+
+	_, err := tempoCache.ListTempoResources(context.Background())
+	require.Error(t, err)
+	require.True(t, apierrors.IsNotFound(err))
+}
+
+func TestTempoCacheTenancyModes(t *testing.T) {
+	staticStack := newTempoStack("ns1", "static-stack", "dev")
+	require.NoError(t, unstructured.SetNestedField(staticStack.Object, "static", "spec", "tenants", "mode"))
+	unsupportedStack := newTempoStack("ns1", "unsupported-stack", "dev")
+	require.NoError(t, unstructured.SetNestedField(unsupportedStack.Object, "unknown", "spec", "tenants", "mode"))
+
+	tempoCache := NewTempoCache(newFakeDynamicClient(
+		newTempoStack("ns1", "stack", "dev"),
+		staticStack,
+		unsupportedStack,
+	), TempoCacheOptions{})
+	startTempoCache(t, tempoCache)
+
+	resources, err := tempoCache.ListTempoResources(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []TempoResource{
+		{Kind: KindTempoStack, Namespace: "ns1", Name: "stack", Tenants: []string{"dev"}, TenancyMode: TenancyModeOpenShift},
+		{Kind: KindTempoStack, Namespace: "ns1", Name: "static-stack", Tenants: []string{"dev"}, TenancyMode: TenancyModeStatic},
+	}, resources)
+}
+
+func TestTempoCacheStaticDatasources(t *testing.T) {
+	tempoCache := NewTempoCache(newFakeDynamicClient(
+		newTempoStack("ns1", "stack", "dev"),
+	), TempoCacheOptions{
+		StaticDatasources: []StaticDatasource{
+			{Name: "shared", URL: "https://tempo.example.com:3200"},
+			{Name: "helm", URL: "http://tempo.observability.svc:3200", Tenants: []string{"dev"}},
+			{Name: "helm", URL: "http://duplicate.observability.svc:3200"},
+			{Name: "Invalid_Name", URL: "https://tempo.example.com"},
+			{Name: "relative-url", URL: "/tempo"},
+			{Name: "missing-token-file", URL: "https://tempo.example.com", Authorization: DatasourceAuthorization{Type: AuthorizationTokenFile}},
+		},
+	})
+	startTempoCache(t, tempoCache)
+
+	resources, err := tempoCache.ListTempoResources(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []TempoResource{
+		{Kind: KindTempoStack, Namespace: "ns1", Name: "stack", Tenants: []string{"dev"}, TenancyMode: TenancyModeOpenShift},
+		{Kind: KindStaticDatasource, Namespace: StaticDatasourceNamespace, Name: "shared", Tenants: []string{}, SingleTenant: true},
+		{Kind: KindStaticDatasource, Namespace: StaticDatasourceNamespace, Name: "helm", Tenants: []string{"dev"}},
+	}, resources)
+
+	tempo, found, err := tempoCache.GetTempoResource(context.Background(), StaticDatasourceNamespace, "helm")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, TempoResource{Kind: KindStaticDatasource, Namespace: StaticDatasourceNamespace, Name: "helm", Tenants: []string{"dev"}}, tempo)
+
+	ds, found := tempoCache.GetStaticDatasource("helm")
+	require.True(t, found)
+	require.Equal(t, "http://tempo.observability.svc:3200", ds.URL)
+
+	_, found, err = tempoCache.GetTempoResource(context.Background(), StaticDatasourceNamespace, "relative-url")
+	require.NoError(t, err)
+	require.False(t, found)
+}
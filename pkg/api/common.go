@@ -15,6 +15,32 @@ type Response struct {
 
 type StatusType string
 
+// Error types which can be interpreted by the frontend
+const (
+	ErrorTypeTempoCRDNotFound = "TempoCRDNotFound"
+	ErrorTypeUnauthorized     = "Unauthorized"
+	ErrorTypeForbidden        = "Forbidden"
+	// the Tempo gateway rejected the request forwarded by the plugin
+	ErrorTypeGatewayUnauthorized = "GatewayUnauthorized"
+	ErrorTypeGatewayForbidden    = "GatewayForbidden"
+	// the path or method is not in the allowlist of the proxy
+	ErrorTypePathNotAllowed = "PathNotAllowed"
+	// a rate limit or concurrency limit of the proxy was exceeded, the response contains a Retry-After header
+	ErrorTypeTooManyRequests = "TooManyRequests"
+	// errors of the proxy and of the connection to Tempo
+	ErrorTypeBadRequest          = "BadRequest"
+	ErrorTypeNotFound            = "NotFound"
+	ErrorTypeTempoNotFound       = "TempoNotFound"
+	ErrorTypeTenantNotFound      = "TenantNotFound"
+	ErrorTypeTenantNotConfigured = "TenantNotConfigured"
+	ErrorTypeTLSConfig           = "TLSConfigError"
+	ErrorTypeGatewayUnreachable  = "GatewayUnreachable"
+	ErrorTypeGatewayTLS          = "GatewayTLSError"
+	ErrorTypeGatewayTimeout      = "GatewayTimeout"
+	ErrorTypeRequestCanceled     = "RequestCanceled"
+	ErrorTypeInternal            = "InternalError"
+)
+
 const (
 	StatusSuccess StatusType = "success"
 	StatusError   StatusType = "error"
@@ -36,3 +62,12 @@ func writeResponse(w http.ResponseWriter, code int, r Response) {
 	w.WriteHeader(code)
 	w.Write(bytes)
 }
+
+// WriteErrorResponse writes an error in the JSON response format of the plugin backend.
+func WriteErrorResponse(w http.ResponseWriter, code int, errorType string, err error) {
+	writeResponse(w, code, Response{
+		Status:    StatusError,
+		ErrorType: errorType,
+		Error:     err.Error(),
+	})
+}
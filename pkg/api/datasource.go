@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// StaticDatasourceNamespace is the namespace of static datasources in the list of Tempo resources and in proxy URLs.
+// It is not a valid Kubernetes namespace name, therefore it cannot collide with the namespace of a Tempo CR.
+const StaticDatasourceNamespace = "_static"
+
+// StaticDatasource is a Tempo instance which is not managed by the Tempo operator,
+// for example a Tempo instance deployed with Helm, or a shared Tempo instance outside of the cluster.
+type StaticDatasource struct {
+	Name string `json:"name" yaml:"name"`
+	// URL is the base URL of the Tempo API, e.g. https://tempo.example.com:3200
+	URL string `json:"url" yaml:"url"`
+	// Tenants of a multi-tenant Tempo instance. The tenant is sent in the X-Scope-OrgID header.
+	Tenants []string `json:"tenants,omitempty" yaml:"tenants,omitempty"`
+	// TLS configures the CA bundle and client certificate. The system CA bundle is used if no CA is set.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// Authorization configures the Authorization header sent to the Tempo instance.
+	Authorization DatasourceAuthorization `json:"authorization,omitempty" yaml:"authorization,omitempty"`
+}
+
+type AuthorizationType string
+
+const (
+	// AuthorizationNone does not send an Authorization header. This is the default.
+	AuthorizationNone AuthorizationType = "None"
+	// AuthorizationUserToken forwards the bearer token of the console user.
+	AuthorizationUserToken AuthorizationType = "UserToken"
+	// AuthorizationTokenFile sends the bearer token read from a file.
+	AuthorizationTokenFile AuthorizationType = "TokenFile"
+)
+
+type DatasourceAuthorization struct {
+	Type AuthorizationType `json:"type,omitempty" yaml:"type,omitempty"`
+	// TokenFile is the path of the file containing the bearer token, if the type is TokenFile.
+	TokenFile string `json:"tokenFile,omitempty" yaml:"tokenFile,omitempty"`
+}
+
+// TLSConfig configures the CA bundle used to verify the certificate of a Tempo instance,
+// and the client certificate for mutual TLS. Files and Kubernetes objects are reloaded periodically.
+// Referenced ConfigMaps and Secrets must be readable by the service account of the plugin.
+type TLSConfig struct {
+	// CAFile is the path of the CA bundle.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// CA references a key of a ConfigMap or Secret containing the CA bundle.
+	CA *ObjectKeyReference `json:"ca,omitempty" yaml:"ca,omitempty"`
+	// CertFile and KeyFile are the paths of the client certificate and key.
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	// CertSecret references a Secret of type kubernetes.io/tls containing the client certificate and key.
+	CertSecret *ObjectReference `json:"certSecret,omitempty" yaml:"certSecret,omitempty"`
+}
+
+type ObjectReference struct {
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Name      string `json:"name" yaml:"name"`
+}
+
+type ObjectKeyReference struct {
+	// Kind is either ConfigMap or Secret.
+	Kind      string `json:"kind" yaml:"kind"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Name      string `json:"name" yaml:"name"`
+	// Key defaults to ca.crt
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+}
+
+// Validate checks if the references of a TLS config are complete, and if at most one source is set for each item.
+func (c *TLSConfig) Validate() error {
+	if c.CAFile != "" && c.CA != nil {
+		return errors.New("only one of caFile and ca can be set")
+	}
+	if c.CA != nil {
+		if c.CA.Kind != "ConfigMap" && c.CA.Kind != "Secret" {
+			return fmt.Errorf("kind '%s' of the CA reference is not supported, must be ConfigMap or Secret", c.CA.Kind)
+		}
+		if c.CA.Namespace == "" || c.CA.Name == "" {
+			return errors.New("namespace and name of the CA reference are required")
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("certFile and keyFile must be set together")
+	}
+	if c.CertFile != "" && c.CertSecret != nil {
+		return errors.New("only one of certFile and certSecret can be set")
+	}
+	if c.CertSecret != nil && (c.CertSecret.Namespace == "" || c.CertSecret.Name == "") {
+		return errors.New("namespace and name of the certificate secret are required")
+	}
+	return nil
+}
+
+// Validate checks if all required fields of a static datasource are set.
+func (ds *StaticDatasource) Validate() error {
+	if errs := validation.IsDNS1123Subdomain(ds.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid datasource name '%s': %s", ds.Name, errs[0])
+	}
+
+	u, err := url.Parse(ds.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL of datasource '%s': %w", ds.Name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL of datasource '%s': only absolute http and https URLs are supported", ds.Name)
+	}
+
+	if err := ds.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS config of datasource '%s': %w", ds.Name, err)
+	}
+
+	switch ds.Authorization.Type {
+	case "", AuthorizationNone, AuthorizationUserToken:
+	case AuthorizationTokenFile:
+		if ds.Authorization.TokenFile == "" {
+			return fmt.Errorf("datasource '%s' uses authorization type %s, but no token file is set", ds.Name, AuthorizationTokenFile)
+		}
+	default:
+		return fmt.Errorf("authorization type '%s' of datasource '%s' is not supported", ds.Authorization.Type, ds.Name)
+	}
+	return nil
+}
+
+func (ds *StaticDatasource) tempoResource() TempoResource {
+	tenants := []string{}
+	tenants = append(tenants, ds.Tenants...)
+
+	return TempoResource{
+		Kind:         KindStaticDatasource,
+		Namespace:    StaticDatasourceNamespace,
+		Name:         ds.Name,
+		Tenants:      tenants,
+		SingleTenant: len(tenants) == 0,
+	}
+}
@@ -2,25 +2,47 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/auth"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/dynamic"
 )
 
 var log = logrus.WithField("module", "api")
 
+// maximum number of concurrent SubjectAccessReviews per request
+const accessReviewConcurrency = 8
+
 type TempoResource struct {
 	Kind      KindType `json:"kind"`
 	Namespace string   `json:"namespace"`
 	Name      string   `json:"name"`
 	// A list of tenant names for multi-tenant instances, or an empty list for single-tenant instances.
 	Tenants []string `json:"tenants,omitempty"`
+	// TenancyMode is the multi-tenancy mode of the gateway, or empty for single-tenant instances.
+	TenancyMode TenancyModeType `json:"tenancyMode,omitempty"`
+	// SingleTenant is true for instances without multi-tenancy, which are queried directly
+	// instead of through the gateway. They are only listed if enabled in the plugin configuration.
+	SingleTenant bool `json:"singleTenant"`
+}
+
+// SingleTenantPlaceholder is the tenant in the proxy path of single-tenant instances, which have no tenants.
+const SingleTenantPlaceholder = "single-tenant"
+
+// HasTenant checks if a tenant in the proxy path is valid for this instance.
+// Single-tenant instances only accept SingleTenantPlaceholder.
+func (t *TempoResource) HasTenant(tenant string) bool {
+	if t.SingleTenant {
+		return tenant == SingleTenantPlaceholder
+	}
+	return slices.Contains(t.Tenants, tenant)
 }
 
 type KindType string
@@ -28,6 +50,17 @@ type KindType string
 const (
 	KindTempoStack      KindType = "TempoStack"
 	KindTempoMonolithic KindType = "TempoMonolithic"
+	// KindStaticDatasource is a Tempo instance configured in the plugin configuration
+	KindStaticDatasource KindType = "StaticDatasource"
+)
+
+type TenancyModeType string
+
+const (
+	// Tenants are authenticated with OpenShift OAuth, and authorized with SubjectAccessReviews.
+	TenancyModeOpenShift TenancyModeType = "openshift"
+	// Tenants are authenticated with OIDC, and authorized with static RBAC rules in the Tempo CR.
+	TenancyModeStatic TenancyModeType = "static"
 )
 
 var (
@@ -43,14 +76,28 @@ var (
 	}
 )
 
-func ListTempoResourcesHandler(k8sclient *dynamic.DynamicClient) http.HandlerFunc {
+func ListTempoResourcesHandler(tempoCache *TempoCache, authorizer *auth.Authorizer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		resources, err := ListTempoResources(r.Context(), k8sclient)
+		user, err := authorizer.AuthenticateRequest(r.Context(), r)
+		if err != nil {
+			if errors.Is(err, auth.ErrUnauthenticated) {
+				WriteErrorResponse(w, http.StatusUnauthorized, ErrorTypeUnauthorized, err)
+				return
+			}
+
+			writeResponse(w, http.StatusInternalServerError, Response{
+				Status: StatusError,
+				Error:  err.Error(),
+			})
+			return
+		}
+
+		resources, err := tempoCache.ListTempoResources(r.Context())
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				writeResponse(w, http.StatusNotFound, Response{
 					Status:    StatusError,
-					ErrorType: "TempoCRDNotFound",
+					ErrorType: ErrorTypeTempoCRDNotFound,
 					Error:     err.Error(),
 				})
 				return
@@ -63,6 +110,15 @@ func ListTempoResourcesHandler(k8sclient *dynamic.DynamicClient) http.HandlerFun
 			return
 		}
 
+		resources, err = filterAccessibleTempoResources(r.Context(), authorizer, user, resources)
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, Response{
+				Status: StatusError,
+				Error:  err.Error(),
+			})
+			return
+		}
+
 		writeResponse(w, http.StatusOK, Response{
 			Status: StatusSuccess,
 			Data:   resources,
@@ -70,114 +126,158 @@ func ListTempoResourcesHandler(k8sclient *dynamic.DynamicClient) http.HandlerFun
 	})
 }
 
-func ListTempoResources(ctx context.Context, k8sclient *dynamic.DynamicClient) ([]TempoResource, error) {
-	tempostacks, err := listTempos(ctx, k8sclient, tempostackGVR)
-	if err != nil {
-		return nil, err
-	}
+// filterAccessibleTempoResources returns the Tempo resources and tenants which can be queried by a user.
+// A user requires read access to the namespace of the Tempo instance, and read access to the traces of a tenant.
+// Single-tenant static datasources are accessible to all users.
+func filterAccessibleTempoResources(ctx context.Context, authorizer *auth.Authorizer, user *auth.User, resources []TempoResource) ([]TempoResource, error) {
+	accessible := make([]*TempoResource, len(resources))
 
-	tempomonolithics, err := listTempos(ctx, k8sclient, tempomonolithicGVR)
-	if err != nil {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(accessReviewConcurrency)
+	for i, resource := range resources {
+		g.Go(func() error {
+			// static datasources do not belong to a namespace
+			if resource.Kind != KindStaticDatasource {
+				allowed, _, err := authorizer.Authorize(ctx, user, auth.ReadNamespaceAttributes(resource.Namespace))
+				if err != nil || !allowed {
+					return err
+				}
+			}
+
+			if resource.SingleTenant {
+				accessible[i] = &resource
+				return nil
+			}
+
+			tenants := []string{}
+			for _, tenant := range resource.Tenants {
+				allowed, _, err := authorizer.Authorize(ctx, user, auth.ReadTracesAttributes(tenant))
+				if err != nil {
+					return err
+				}
+				if allowed {
+					tenants = append(tenants, tenant)
+				}
+			}
+
+			if len(tenants) > 0 {
+				resource.Tenants = tenants
+				accessible[i] = &resource
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
 		return nil, err
 	}
 
-	return append(tempostacks, tempomonolithics...), nil
+	filtered := []TempoResource{}
+	for _, resource := range accessible {
+		if resource != nil {
+			filtered = append(filtered, *resource)
+		}
+	}
+	return filtered, nil
 }
 
-func listTempos(ctx context.Context, k8sclient *dynamic.DynamicClient, gvr schema.GroupVersionResource) ([]TempoResource, error) {
-	resources := []TempoResource{}
+// tempoResourceFromCR converts a TempoStack or TempoMonolithic CR to a TempoResource.
+// The boolean return value is false if the CR is invalid or not supported by the plugin.
+func tempoResourceFromCR(resource *unstructured.Unstructured, singleTenantInstances bool) (TempoResource, bool) {
+	itemLogger := log.WithFields(logrus.Fields{"namespace": resource.GetNamespace(), "tempo": resource.GetName()})
 
-	resourceList, err := k8sclient.Resource(gvr).List(ctx, metav1.ListOptions{})
+	mode, tenants, err := readTenantsFromCR(resource)
 	if err != nil {
-		return nil, fmt.Errorf("cannot list %s resource: %w", gvr.String(), err)
+		itemLogger.Error(err)
+		return TempoResource{}, false
 	}
-	for _, resource := range resourceList.Items {
-		itemLogger := log.WithFields(logrus.Fields{"namespace": resource.GetNamespace(), "tempo": resource.GetName()})
-
-		tenants, err := readTenantsFromCR(&resource)
-		if err != nil {
-			itemLogger.Error(err)
-			continue
-		}
 
-		// Fix for https://issues.redhat.com/browse/OU-467
-		if len(tenants) == 0 {
-			itemLogger.Debug("skipping Tempo instance without multi-tenancy")
-			continue
-		}
+	// requests to multitenant instances must pass the gateway, even if no tenants are configured
+	singleTenant := mode == ""
+	if !singleTenant && len(tenants) == 0 {
+		itemLogger.Warn("skipping multitenant Tempo instance without tenants")
+		return TempoResource{}, false
+	}
 
-		resources = append(resources, TempoResource{
-			Kind:      KindType(resource.GetKind()),
-			Namespace: resource.GetNamespace(),
-			Name:      resource.GetName(),
-			Tenants:   tenants,
-		})
+	// Fix for https://issues.redhat.com/browse/OU-467
+	if singleTenant && !singleTenantInstances {
+		itemLogger.Debug("skipping Tempo instance without multi-tenancy")
+		return TempoResource{}, false
 	}
 
-	return resources, nil
+	return TempoResource{
+		Kind:         KindType(resource.GetKind()),
+		Namespace:    resource.GetNamespace(),
+		Name:         resource.GetName(),
+		Tenants:      tenants,
+		TenancyMode:  mode,
+		SingleTenant: singleTenant,
+	}, true
 }
 
-func readTenantsFromCR(spec *unstructured.Unstructured) ([]string, error) {
+func readTenantsFromCR(spec *unstructured.Unstructured) (TenancyModeType, []string, error) {
 	switch KindType(spec.GetKind()) {
 	case KindTempoStack:
-		found, err := validateTenancyMode(spec, "spec", "tenants", "mode")
+		mode, found, err := readTenancyMode(spec, "spec", "tenants", "mode")
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
 		if !found {
 			// no tenants mode set: instance without multitenancy
-			return []string{}, nil
+			return "", []string{}, nil
 		}
 
 		tenants, err := extractTenantNames(spec, "spec", "tenants", "authentication")
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
-		return tenants, nil
+		return mode, tenants, nil
 
 	case KindTempoMonolithic:
 		enabled, found, err := unstructured.NestedBool(spec.Object, "spec", "multitenancy", "enabled")
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
 		if !found || !enabled {
-			return []string{}, nil
+			return "", []string{}, nil
 		}
 
-		found, err = validateTenancyMode(spec, "spec", "multitenancy", "mode")
+		mode, found, err := readTenancyMode(spec, "spec", "multitenancy", "mode")
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
 		if !found {
 			// multitenancy enabled but mode not set.
 			// mode is a required field, this condition should not happen.
-			return []string{}, nil
+			return "", nil, errors.New("multitenancy is enabled, but no mode is set")
 		}
 
 		tenants, err := extractTenantNames(spec, "spec", "multitenancy", "authentication")
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
-		return tenants, nil
+		return mode, tenants, nil
 
 	default:
-		return nil, fmt.Errorf("invalid Tempo resource with kind '%s'", spec.GetKind())
+		return "", nil, fmt.Errorf("invalid Tempo resource with kind '%s'", spec.GetKind())
 	}
 }
 
-func validateTenancyMode(spec *unstructured.Unstructured, fields ...string) (bool, error) {
+func readTenancyMode(spec *unstructured.Unstructured, fields ...string) (TenancyModeType, bool, error) {
 	mode, found, err := unstructured.NestedString(spec.Object, fields...)
 	if err != nil {
-		return false, err
+		return "", false, err
 	}
 	if !found {
 		// instance without multitenancy
-		return false, nil
+		return "", false, nil
 	}
-	if mode == "openshift" {
-		return true, nil
+
+	switch TenancyModeType(mode) {
+	case TenancyModeOpenShift, TenancyModeStatic:
+		return TenancyModeType(mode), true, nil
+	default:
+		return "", true, fmt.Errorf("multitenancy mode '%s' is not supported", mode)
 	}
-	return true, fmt.Errorf("multitenancy mode '%s' is not supported", mode)
 }
 
 func extractTenantNames(spec *unstructured.Unstructured, fields ...string) ([]string, error) {
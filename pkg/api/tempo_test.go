@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/auth"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeAuthorizer returns an authorizer which authenticates the token "valid-token" as user "developer"
+// with read access to the given namespaces and tenants.
+func newFakeAuthorizer(namespaces []string, tenants []string) *auth.Authorizer {
+	k8sclient := fake.NewClientset()
+	k8sclient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "valid-token" {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: "developer"}
+		}
+		return true, review, nil
+	})
+	k8sclient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		if attributes.Resource == "namespaces" {
+			review.Status.Allowed = slices.Contains(namespaces, attributes.Name)
+		} else {
+			review.Status.Allowed = slices.Contains(tenants, attributes.Resource)
+		}
+		return true, review, nil
+	})
+	return auth.NewAuthorizer(k8sclient)
+}
+
+func TestListTempoResourcesHandler(t *testing.T) {
+	tempoCache := NewTempoCache(newFakeDynamicClient(
+		newTempoStack("ns1", "stack", "dev", "prod"),
+		newTempoStack("ns1", "prod-only", "prod"),
+		newTempoStack("ns2", "stack", "dev"),
+		newTempoMonolithic("ns1", "single-tenant-mono"),
+		newTempoMonolithic("ns2", "single-tenant-mono"),
+	), TempoCacheOptions{
+		SingleTenantInstances: true,
+		StaticDatasources: []StaticDatasource{
+			{Name: "shared", URL: "https://tempo.example.com"},
+			{Name: "multi", URL: "https://tempo.example.com", Tenants: []string{"dev", "prod"}},
+			{Name: "prod-only", URL: "https://tempo.example.com", Tenants: []string{"prod"}},
+		},
+	})
+	startTempoCache(t, tempoCache)
+	handler := ListTempoResourcesHandler(tempoCache, newFakeAuthorizer([]string{"ns1"}, []string{"dev"}))
+
+	req := httptest.NewRequest("GET", "/api/v1/list-tempo-resources", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest("GET", "/api/v1/list-tempo-resources", nil)
+	req.Header.Set("Authorization", "Bearer valid-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Status    StatusType      `json:"status"`
+		Resources []TempoResource `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, StatusSuccess, response.Status)
+	require.Equal(t, []TempoResource{
+		{Kind: KindTempoStack, Namespace: "ns1", Name: "stack", Tenants: []string{"dev"}, TenancyMode: TenancyModeOpenShift},
+		{Kind: KindTempoMonolithic, Namespace: "ns1", Name: "single-tenant-mono", SingleTenant: true},
+		{Kind: KindStaticDatasource, Namespace: StaticDatasourceNamespace, Name: "shared", SingleTenant: true},
+		{Kind: KindStaticDatasource, Namespace: StaticDatasourceNamespace, Name: "multi", Tenants: []string{"dev"}},
+	}, response.Resources)
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var log = logrus.WithField("module", "auth")
+
+const (
+	tokenCacheSize = 1024
+	tokenCacheTTL  = time.Minute
+
+	decisionCacheSize = 8192
+	decisionCacheTTL  = 30 * time.Second
+)
+
+// ErrUnauthenticated is returned if a request does not contain a valid bearer token.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// User is a console user, identified by the bearer token forwarded by the console.
+type User struct {
+	Name   string
+	UID    string
+	Groups []string
+	Extra  map[string]authorizationv1.ExtraValue
+}
+
+// Authorizer authenticates console users with TokenReviews
+// and checks their permissions with SubjectAccessReviews.
+type Authorizer struct {
+	k8sclient kubernetes.Interface
+	// authenticated users by SHA-256 hash of their token
+	tokenCache *expirable.LRU[string, *User]
+	// results of SubjectAccessReviews by user and attributes
+	decisionCache *expirable.LRU[string, decision]
+}
+
+type decision struct {
+	allowed bool
+	reason  string
+}
+
+func NewAuthorizer(k8sclient kubernetes.Interface) *Authorizer {
+	return &Authorizer{
+		k8sclient:     k8sclient,
+		tokenCache:    expirable.NewLRU[string, *User](tokenCacheSize, nil, tokenCacheTTL),
+		decisionCache: expirable.NewLRU[string, decision](decisionCacheSize, nil, decisionCacheTTL),
+	}
+}
+
+// BearerToken returns the bearer token of the Authorization header of a request.
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// AuthenticateRequest returns the user of the bearer token of a request.
+func (a *Authorizer) AuthenticateRequest(ctx context.Context, r *http.Request) (*User, error) {
+	token, ok := BearerToken(r)
+	if !ok {
+		return nil, fmt.Errorf("%w: bearer token not found", ErrUnauthenticated)
+	}
+	return a.Authenticate(ctx, token)
+}
+
+// Authenticate returns the user of a bearer token.
+func (a *Authorizer) Authenticate(ctx context.Context, token string) (*User, error) {
+	hash := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(hash[:])
+	if user, ok := a.tokenCache.Get(cacheKey); ok {
+		return user, nil
+	}
+
+	review, err := a.k8sclient.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create token review: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, review.Status.Error)
+	}
+
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range review.Status.User.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	user := &User{
+		Name:   review.Status.User.Username,
+		UID:    review.Status.User.UID,
+		Groups: review.Status.User.Groups,
+		Extra:  extra,
+	}
+
+	a.tokenCache.Add(cacheKey, user)
+	return user, nil
+}
+
+// Authorize checks if a user is allowed to perform an action.
+// If the user is not allowed, the returned string contains the reason, if any.
+// Decisions are cached per user for a short time.
+func (a *Authorizer) Authorize(ctx context.Context, user *User, attributes authorizationv1.ResourceAttributes) (bool, string, error) {
+	return a.authorize(ctx, user, fmt.Sprintf("%+v", attributes), authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &attributes,
+	})
+}
+
+// AuthorizeNonResource checks if a user is allowed to access a non-resource URL, for example /metrics.
+// It behaves like Authorize.
+func (a *Authorizer) AuthorizeNonResource(ctx context.Context, user *User, attributes authorizationv1.NonResourceAttributes) (bool, string, error) {
+	return a.authorize(ctx, user, fmt.Sprintf("nonresource/%+v", attributes), authorizationv1.SubjectAccessReviewSpec{
+		NonResourceAttributes: &attributes,
+	})
+}
+
+func (a *Authorizer) authorize(ctx context.Context, user *User, attributesKey string, spec authorizationv1.SubjectAccessReviewSpec) (bool, string, error) {
+	// the groups are part of the cache key, because group memberships can change while the token stays valid.
+	// The extra attributes contain the scopes of OpenShift tokens, which limit the permissions of a token.
+	cacheKey := fmt.Sprintf("%s/%s/%s/%s/%s", user.UID, user.Name, strings.Join(user.Groups, ","), extraCacheKey(user.Extra), attributesKey)
+	if d, ok := a.decisionCache.Get(cacheKey); ok {
+		return d.allowed, d.reason, nil
+	}
+
+	spec.User = user.Name
+	spec.UID = user.UID
+	spec.Groups = user.Groups
+	spec.Extra = user.Extra
+	review, err := a.k8sclient.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: spec,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("cannot create subject access review: %w", err)
+	}
+
+	if !review.Status.Allowed {
+		log.WithFields(logrus.Fields{"user": user.Name, "reason": review.Status.Reason}).Debugf("access denied: %s", attributesKey)
+	}
+
+	a.decisionCache.Add(cacheKey, decision{allowed: review.Status.Allowed, reason: review.Status.Reason})
+	return review.Status.Allowed, review.Status.Reason, nil
+}
+
+// extraCacheKey returns a stable encoding of the extra attributes of a user, with sorted keys and values.
+func extraCacheKey(extra map[string]authorizationv1.ExtraValue) string {
+	sorted := make(map[string][]string, len(extra))
+	for k, v := range extra {
+		sorted[k] = slices.Sorted(slices.Values(v))
+	}
+	// maps are encoded with sorted keys
+	encoded, _ := json.Marshal(sorted)
+	return string(encoded)
+}
+
+// ReadTracesAttributes returns the attributes which are required to read the traces of a tenant.
+// They match the attributes checked by the Tempo gateway in openshift tenancy mode.
+func ReadTracesAttributes(tenant string) authorizationv1.ResourceAttributes {
+	return authorizationv1.ResourceAttributes{
+		Group:    "tempo.grafana.com",
+		Resource: tenant,
+		Name:     "traces",
+		Verb:     "get",
+	}
+}
+
+// ReadNamespaceAttributes returns the attributes which are required to read a namespace.
+func ReadNamespaceAttributes(namespace string) authorizationv1.ResourceAttributes {
+	// the API server sets the namespace attribute of requests to namespace objects
+	// to the name of the namespace, therefore RoleBindings in the namespace apply
+	return authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Resource:  "namespaces",
+		Name:      namespace,
+		Verb:      "get",
+	}
+}
+
+// ReadMetricsAttributes returns the attributes which are required to read the metrics of the plugin backend.
+// They match the attributes checked by kube-rbac-proxy, which are granted to Prometheus by default.
+func ReadMetricsAttributes() authorizationv1.NonResourceAttributes {
+	return authorizationv1.NonResourceAttributes{
+		Path: "/metrics",
+		Verb: "get",
+	}
+}
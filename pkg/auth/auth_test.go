@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeClientset returns a fake Kubernetes API which authenticates the token "valid-token" as user "developer"
+// and allows all actions for which allowed returns true.
+func newFakeClientset(allowed func(attributes *authorizationv1.ResourceAttributes) bool) *fake.Clientset {
+	k8sclient := fake.NewClientset()
+	k8sclient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "valid-token" {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: "developer", Groups: []string{"system:authenticated"}}
+		}
+		return true, review, nil
+	})
+	k8sclient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = review.Spec.User == "developer" && allowed(review.Spec.ResourceAttributes)
+		return true, review, nil
+	})
+	return k8sclient
+}
+
+func countActions(k8sclient *fake.Clientset, resource string) int {
+	count := 0
+	for _, action := range k8sclient.Actions() {
+		if action.GetResource().Resource == resource {
+			count++
+		}
+	}
+	return count
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		token         string
+		found         bool
+	}{
+		{name: "bearer token", authorization: "Bearer abc", token: "abc", found: true},
+		{name: "case insensitive scheme", authorization: "bearer abc", token: "abc", found: true},
+		{name: "missing header", authorization: "", found: false},
+		{name: "empty token", authorization: "Bearer ", found: false},
+		{name: "basic auth", authorization: "Basic YWJjOmRlZg==", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			token, found := BearerToken(r)
+			require.Equal(t, tt.found, found)
+			require.Equal(t, tt.token, token)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	k8sclient := newFakeClientset(func(*authorizationv1.ResourceAttributes) bool { return true })
+	authorizer := NewAuthorizer(k8sclient)
+
+	user, err := authorizer.Authenticate(context.Background(), "valid-token")
+	require.NoError(t, err)
+	require.Equal(t, "developer", user.Name)
+
+	// the result is cached
+	_, err = authorizer.Authenticate(context.Background(), "valid-token")
+	require.NoError(t, err)
+	require.Equal(t, 1, countActions(k8sclient, "tokenreviews"))
+
+	_, err = authorizer.Authenticate(context.Background(), "invalid-token")
+	require.True(t, errors.Is(err, ErrUnauthenticated))
+
+	_, err = authorizer.AuthenticateRequest(context.Background(), httptest.NewRequest("GET", "/", nil))
+	require.True(t, errors.Is(err, ErrUnauthenticated))
+}
+
+func TestAuthorize(t *testing.T) {
+	authorizer := NewAuthorizer(newFakeClientset(func(attributes *authorizationv1.ResourceAttributes) bool {
+		return attributes.Group == "tempo.grafana.com" && attributes.Resource == "dev" && attributes.Name == "traces" && attributes.Verb == "get"
+	}))
+
+	user, err := authorizer.Authenticate(context.Background(), "valid-token")
+	require.NoError(t, err)
+
+	allowed, _, err := authorizer.Authorize(context.Background(), user, ReadTracesAttributes("dev"))
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	allowed, _, err = authorizer.Authorize(context.Background(), user, ReadTracesAttributes("prod"))
+	require.NoError(t, err)
+	require.False(t, allowed)
+}
+
+func TestAuthorizeNonResource(t *testing.T) {
+	k8sclient := newFakeClientset(func(*authorizationv1.ResourceAttributes) bool { return true })
+	k8sclient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == "prometheus" && attributes != nil && attributes.Path == "/metrics" && attributes.Verb == "get"
+		return true, review, nil
+	})
+	authorizer := NewAuthorizer(k8sclient)
+
+	allowed, _, err := authorizer.AuthorizeNonResource(context.Background(), &User{Name: "prometheus"}, ReadMetricsAttributes())
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	allowed, _, err = authorizer.AuthorizeNonResource(context.Background(), &User{Name: "developer"}, ReadMetricsAttributes())
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	// decisions are cached per attributes
+	allowed, _, err = authorizer.AuthorizeNonResource(context.Background(), &User{Name: "prometheus"}, authorizationv1.NonResourceAttributes{Path: "/metrics", Verb: "delete"})
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Equal(t, 3, countActions(k8sclient, "subjectaccessreviews"))
+}
+
+func TestAuthorizeCache(t *testing.T) {
+	k8sclient := newFakeClientset(func(*authorizationv1.ResourceAttributes) bool { return true })
+	authorizer := NewAuthorizer(k8sclient)
+
+	user, err := authorizer.Authenticate(context.Background(), "valid-token")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := authorizer.Authorize(context.Background(), user, ReadTracesAttributes("dev"))
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	require.Equal(t, 1, countActions(k8sclient, "subjectaccessreviews"))
+
+	// decisions are cached per user
+	otherUser := &User{Name: "other"}
+	allowed, _, err := authorizer.Authorize(context.Background(), otherUser, ReadTracesAttributes("dev"))
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Equal(t, 2, countActions(k8sclient, "subjectaccessreviews"))
+
+	// decisions are cached per token scopes
+	scopedUser := &User{Name: user.Name, Groups: user.Groups, Extra: map[string]authorizationv1.ExtraValue{
+		"scopes.authorization.openshift.io": {"user:info", "user:check-access"},
+	}}
+	_, _, err = authorizer.Authorize(context.Background(), scopedUser, ReadTracesAttributes("dev"))
+	require.NoError(t, err)
+	require.Equal(t, 3, countActions(k8sclient, "subjectaccessreviews"))
+
+	reorderedScopes := &User{Name: user.Name, Groups: user.Groups, Extra: map[string]authorizationv1.ExtraValue{
+		"scopes.authorization.openshift.io": {"user:check-access", "user:info"},
+	}}
+	_, _, err = authorizer.Authorize(context.Background(), reorderedScopes, ReadTracesAttributes("dev"))
+	require.NoError(t, err)
+	require.Equal(t, 3, countActions(k8sclient, "subjectaccessreviews"))
+}
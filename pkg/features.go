@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// featureRegistryFile is the name of the feature registry in the config path, next to the patches of the features.
+const featureRegistryFile = "features.json"
+
+// featureDefinition declares the relations of a feature to other features.
+type featureDefinition struct {
+	Name string `json:"name"`
+	// After lists features whose patches are applied before the patch of this feature, if they are enabled.
+	After []string `json:"after,omitempty"`
+	// Requires lists features which must be enabled together with this feature. Their patches are applied first.
+	Requires []string `json:"requires,omitempty"`
+	// Conflicts lists features which cannot be enabled together with this feature.
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// featureRegistry contains the definitions of the features by name.
+// Features without a definition have no relations to other features.
+type featureRegistry map[string]featureDefinition
+
+// enabledFeature is an enabled feature with its manifest patches.
+type enabledFeature struct {
+	name string
+	// known is true if the feature is declared in the feature registry
+	known   bool
+	patches []manifestPatch
+}
+
+// featureStatus describes the effects of an enabled feature on the plugin manifest, served on /features.
+type featureStatus struct {
+	Enabled bool `json:"enabled"`
+	// Known is true if the feature is declared in the feature registry
+	Known bool `json:"known"`
+	// Patches contains the names of the patch files of the feature
+	Patches []string `json:"patches"`
+	Applied bool     `json:"applied"`
+	// Extensions contains the ids of the console extensions added or changed by the patches of the feature
+	Extensions []string `json:"extensions"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// loadFeatureRegistry reads the feature registry from the config path. The registry is optional.
+func loadFeatureRegistry(configPath string) (featureRegistry, error) {
+	registry := featureRegistry{}
+
+	data, err := os.ReadFile(filepath.Join(configPath, featureRegistryFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read feature registry: %w", err)
+	}
+
+	var definitions struct {
+		Features []featureDefinition `json:"features"`
+	}
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("cannot decode feature registry: %w", err)
+	}
+	for _, definition := range definitions.Features {
+		if definition.Name == "" {
+			return nil, errors.New("invalid feature registry: features must have a name")
+		}
+		if _, ok := registry[definition.Name]; ok {
+			return nil, fmt.Errorf("invalid feature registry: duplicate feature '%s'", definition.Name)
+		}
+		registry[definition.Name] = definition
+	}
+	return registry, nil
+}
+
+// resolveFeatures checks the relations of the enabled features, and loads their manifest patches
+// in the order in which they are applied. All problems are reported at once.
+func resolveFeatures(cfg *Config) ([]enabledFeature, error) {
+	if len(cfg.Features) == 0 {
+		return nil, nil
+	}
+
+	registry, err := loadFeatureRegistry(cfg.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name, enabled := range cfg.Features {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+
+	var errs []error
+	for _, name := range names {
+		definition := registry[name]
+		for _, required := range definition.Requires {
+			if !cfg.Features[required] {
+				errs = append(errs, fmt.Errorf("feature '%s' requires feature '%s', which is not enabled", name, required))
+			}
+		}
+		for _, conflict := range definition.Conflicts {
+			// report each conflict once, even if it is declared by both features
+			if cfg.Features[conflict] && (name < conflict || !slices.Contains(registry[conflict].Conflicts, name)) {
+				errs = append(errs, fmt.Errorf("feature '%s' conflicts with feature '%s'", name, conflict))
+			}
+		}
+	}
+
+	ordered, err := orderFeatures(registry, names)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	features := []enabledFeature{}
+	for _, name := range ordered {
+		patches, err := findManifestPatches(cfg.ConfigPath, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		_, known := registry[name]
+		features = append(features, enabledFeature{name: name, known: known, patches: patches})
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return features, nil
+}
+
+// orderFeatures sorts the features topologically by their After and Requires relations.
+// Features without a relation between them are sorted by name, to apply the patches in a stable order.
+func orderFeatures(registry featureRegistry, names []string) ([]string, error) {
+	enabled := map[string]bool{}
+	for _, name := range names {
+		enabled[name] = true
+	}
+
+	// number of enabled features which must be applied before a feature, and the reverse edges
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for _, name := range names {
+		pending[name] += 0
+		definition := registry[name]
+		for _, before := range slices.Concat(definition.After, definition.Requires) {
+			if enabled[before] && !slices.Contains(dependents[before], name) {
+				pending[name]++
+				dependents[before] = append(dependents[before], name)
+			}
+		}
+	}
+
+	ready := []string{}
+	for name, count := range pending {
+		if count == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	ordered := []string{}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, name)
+
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) < len(names) {
+		cycle := []string{}
+		for name, count := range pending {
+			if count > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("the order of the features %v contains a cycle", cycle)
+	}
+	return ordered, nil
+}
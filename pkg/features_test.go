@@ -0,0 +1,84 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderFeatures(t *testing.T) {
+	registry := featureRegistry{
+		"c":     {Name: "c", Requires: []string{"d"}},
+		"a":     {Name: "a", After: []string{"c", "missing"}},
+		"loop":  {Name: "loop", After: []string{"loop2"}},
+		"loop2": {Name: "loop2", After: []string{"loop"}},
+	}
+
+	ordered, err := orderFeatures(registry, []string{"b", "a", "d", "c"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "d", "c", "a"}, ordered)
+
+	// the order does not depend on the order of the enabled features
+	ordered, err = orderFeatures(registry, []string{"c", "d", "b", "a"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "d", "c", "a"}, ordered)
+
+	_, err = orderFeatures(registry, []string{"loop", "loop2", "a"})
+	require.EqualError(t, err, "the order of the features [loop loop2] contains a cycle")
+}
+
+func TestResolveFeatures(t *testing.T) {
+	configPath := t.TempDir()
+	writeFile := func(name string, data string) {
+		require.NoError(t, os.WriteFile(filepath.Join(configPath, name), []byte(data), 0600))
+	}
+	writeFile(featureRegistryFile, `{"features": [
+		{"name": "dev", "conflicts": ["prod"]},
+		{"name": "prod"},
+		{"name": "links", "requires": ["list"]},
+		{"name": "list"}
+	]}`)
+	writeFile("list.patch.json", `[{"op": "add", "path": "/extensions/-", "value": {"type": "list"}}]`)
+	writeFile("links.patch.json", `[{"op": "add", "path": "/extensions/0/links", "value": true}]`)
+	writeFile("dev.patch.json", `[]`)
+	writeFile("prod.patch.json", `[]`)
+	staticPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(staticPath, "plugin-manifest.json"), []byte(`{"extensions": []}`), 0600))
+
+	cfg := &Config{StaticPath: staticPath, ConfigPath: configPath, Features: map[string]bool{"links": true, "list": true}}
+	features, err := resolveFeatures(cfg)
+	require.NoError(t, err)
+	require.Len(t, features, 2)
+	require.Equal(t, "list", features[0].name)
+	require.Equal(t, "links", features[1].name)
+	require.True(t, features[0].known)
+
+	// the patch of links depends on the extension added by list
+	manifest, err := loadManifest(cfg, features, nil)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"extensions": [{"type": "list", "links": true}]}`, string(manifest.data))
+
+	cfg.Features = map[string]bool{"links": true, "dev": true, "prod": true, "unknown": true}
+	_, err = resolveFeatures(cfg)
+	require.Error(t, err)
+	errs := strings.Split(err.Error(), "\n")
+	require.ElementsMatch(t, []string{
+		"feature 'links' requires feature 'list', which is not enabled",
+		"feature 'dev' conflicts with feature 'prod'",
+		"feature 'unknown' has no patch, expected one of unknown.extensions.json, unknown.merge.json, unknown.patch.json in " + configPath,
+	}, errs)
+}
+
+func TestResolveFeaturesWithoutRegistry(t *testing.T) {
+	configPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configPath, "b.patch.json"), []byte(`[]`), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(configPath, "a.patch.json"), []byte(`[]`), 0600))
+
+	features, err := resolveFeatures(&Config{ConfigPath: configPath, Features: map[string]bool{"b": true, "a": true}})
+	require.NoError(t, err)
+	require.Equal(t, "a", features[0].name)
+	require.Equal(t, "b", features[1].name)
+}
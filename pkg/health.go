@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+const (
+	// maximum duration of the requests of the health checks to the Kubernetes API
+	healthCheckTimeout = 5 * time.Second
+	// results of checks of the Kubernetes API are reused for this duration, to limit the requests of probes
+	healthCheckCacheTTL = 5 * time.Second
+	// the serving certificate is reported as not ready if it expires within this duration
+	certificateExpiryThreshold = 24 * time.Hour
+	tempoGroupVersion          = "tempo.grafana.com/v1alpha1"
+)
+
+// healthCheck is a named check of the /livez and /readyz endpoints.
+type healthCheck struct {
+	name  string
+	check func() error
+	// informational checks only run with the verbose query parameter, and do not fail the endpoint
+	informational bool
+}
+
+type healthCheckResult struct {
+	Name          string `json:"name"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	Informational bool   `json:"informational,omitempty"`
+}
+
+type healthResponse struct {
+	Status string              `json:"status"`
+	Checks []healthCheckResult `json:"checks"`
+}
+
+// healthHandler runs all checks concurrently, and responds with 503 if any check failed.
+// With the verbose query parameter, the result of each check, including the informational checks, is returned as JSON.
+func healthHandler(checks []healthCheck) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verbose := r.URL.Query().Has("verbose")
+		active := checks
+		if !verbose {
+			active = slices.DeleteFunc(slices.Clone(checks), func(check healthCheck) bool { return check.informational })
+		}
+
+		results := make([]healthCheckResult, len(active))
+		var wg sync.WaitGroup
+		for i, check := range active {
+			wg.Go(func() {
+				results[i] = healthCheckResult{Name: check.name, Status: "ok", Informational: check.informational}
+				if err := check.check(); err != nil {
+					results[i].Status = "failed"
+					results[i].Error = err.Error()
+				}
+			})
+		}
+		wg.Wait()
+
+		response := healthResponse{Status: "ok", Checks: results}
+		failed := []string{}
+		for _, result := range results {
+			if result.Status != "ok" && !result.Informational {
+				response.Status = "failed"
+				failed = append(failed, result.Name)
+				log.WithField("check", result.Name).Warnf("%s check failed: %s", r.URL.Path, result.Error)
+			}
+		}
+
+		code := http.StatusOK
+		if len(failed) > 0 {
+			code = http.StatusServiceUnavailable
+		}
+
+		if verbose {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		if len(failed) > 0 {
+			http.Error(w, fmt.Sprintf("failed checks: %s", strings.Join(failed, ", ")), code)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+func pingCheck() healthCheck {
+	return healthCheck{name: "ping", check: func() error {
+		return nil
+	}}
+}
+
+// shutdownCheck fails when the server is shutting down, to remove it from the endpoints of the service.
+func shutdownCheck(ready *atomic.Bool) healthCheck {
+	return healthCheck{name: "shutdown", check: func() error {
+		if !ready.Load() {
+			return errors.New("server is shutting down")
+		}
+		return nil
+	}}
+}
+
+func kubernetesAPICheck(discoveryClient discovery.DiscoveryInterface) healthCheck {
+	return healthCheck{name: "kubernetes-api", check: func() error {
+		_, err := discoveryClient.ServerVersion()
+		if err != nil {
+			return fmt.Errorf("cannot reach the Kubernetes API server: %w", err)
+		}
+		return nil
+	}}
+}
+
+// tempoCRDCheck checks if the TempoStack and TempoMonolithic CRDs are installed.
+// The check is informational: without the CRDs, the frontend shows how to install the Tempo operator.
+func tempoCRDCheck(discoveryClient discovery.DiscoveryInterface) healthCheck {
+	return healthCheck{name: "tempo-crds", informational: true, check: func() error {
+		resources, err := discoveryClient.ServerResourcesForGroupVersion(tempoGroupVersion)
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("API group %s is not installed", tempoGroupVersion)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot discover resources of %s: %w", tempoGroupVersion, err)
+		}
+
+		missing := []string{}
+		for _, name := range []string{"tempostacks", "tempomonolithics"} {
+			if !slices.ContainsFunc(resources.APIResources, func(resource metav1.APIResource) bool { return resource.Name == name }) {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("CRDs not installed: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}}
+}
+
+// cachedCheck reuses the result of a check for the given duration. Concurrent probes wait for the running check.
+func cachedCheck(c healthCheck, ttl time.Duration) healthCheck {
+	var mu sync.Mutex
+	var lastRun time.Time
+	var lastErr error
+	check := c.check
+	c.check = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !lastRun.IsZero() && time.Since(lastRun) < ttl {
+			return lastErr
+		}
+		lastErr = check()
+		lastRun = time.Now()
+		return lastErr
+	}
+	return c
+}
+
+func manifestCheck(manifestErr error) healthCheck {
+	return healthCheck{name: "plugin-manifest", check: func() error {
+		return manifestErr
+	}}
+}
+
+// certificateCheck checks if the serving certificate is valid and does not expire soon.
+// The file is read on every check to pick up rotated certificates.
+func certificateCheck(certFile string) healthCheck {
+	return healthCheck{name: "serving-certificate", check: func() error {
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return fmt.Errorf("cannot read certificate: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return errors.New("no PEM encoded certificate found")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("cannot parse certificate: %w", err)
+		}
+
+		now := time.Now()
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.Add(certificateExpiryThreshold).After(cert.NotAfter) {
+			return fmt.Errorf("certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ready := &atomic.Bool{}
+	ready.Store(true)
+	handler := healthHandler([]healthCheck{
+		pingCheck(),
+		shutdownCheck(ready),
+		manifestCheck(nil),
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/readyz", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "ok", rr.Body.String())
+
+	ready.Store(false)
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, "failed checks: shutdown\n", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/readyz?verbose", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var response healthResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	require.Equal(t, healthResponse{
+		Status: "failed",
+		Checks: []healthCheckResult{
+			{Name: "ping", Status: "ok"},
+			{Name: "shutdown", Status: "failed", Error: "server is shutting down"},
+			{Name: "plugin-manifest", Status: "ok"},
+		},
+	}, response)
+}
+
+func TestHealthHandlerInformationalChecks(t *testing.T) {
+	runs := 0
+	handler := healthHandler([]healthCheck{
+		pingCheck(),
+		{name: "tempo-crds", informational: true, check: func() error {
+			runs++
+			return errors.New("CRDs not installed: tempostacks")
+		}},
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/readyz", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, 0, runs, "informational checks only run in verbose mode")
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/readyz?verbose", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response healthResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	require.Equal(t, healthResponse{
+		Status: "ok",
+		Checks: []healthCheckResult{
+			{Name: "ping", Status: "ok"},
+			{Name: "tempo-crds", Status: "failed", Error: "CRDs not installed: tempostacks", Informational: true},
+		},
+	}, response)
+}
+
+func TestCachedCheck(t *testing.T) {
+	runs := 0
+	check := cachedCheck(healthCheck{name: "kubernetes-api", check: func() error {
+		runs++
+		return nil
+	}}, time.Hour)
+
+	require.NoError(t, check.check())
+	require.NoError(t, check.check())
+	require.Equal(t, 1, runs)
+	require.Equal(t, "kubernetes-api", check.name)
+
+	check = cachedCheck(healthCheck{name: "kubernetes-api", check: func() error {
+		runs++
+		return nil
+	}}, 0)
+	require.NoError(t, check.check())
+	require.NoError(t, check.check())
+	require.Equal(t, 3, runs)
+}
+
+func TestTempoCRDCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		err       string
+	}{
+		{
+			name: "installed",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: tempoGroupVersion,
+				APIResources: []metav1.APIResource{{Name: "tempostacks"}, {Name: "tempomonolithics"}},
+			}},
+		},
+		{
+			name: "TempoMonolithic CRD missing",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: tempoGroupVersion,
+				APIResources: []metav1.APIResource{{Name: "tempostacks"}},
+			}},
+			err: "CRDs not installed: tempomonolithics",
+		},
+		{
+			name: "API group missing",
+			err:  "API group tempo.grafana.com/v1alpha1 is not installed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: tt.resources}}
+			err := tempoCRDCheck(discoveryClient).check()
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestKubernetesAPICheck(t *testing.T) {
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	require.NoError(t, kubernetesAPICheck(discoveryClient).check())
+
+	discoveryClient.PrependReactor("get", "version", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	require.EqualError(t, kubernetesAPICheck(discoveryClient).check(), "cannot reach the Kubernetes API server: connection refused")
+}
+
+func TestCertificateCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeCert := func(name string, notBefore time.Time, notAfter time.Time) string {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: notBefore, NotAfter: notAfter}
+		der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+		return path
+	}
+
+	now := time.Now()
+	require.NoError(t, certificateCheck(writeCert("valid.crt", now.Add(-time.Hour), now.Add(30*24*time.Hour))).check())
+	require.ErrorContains(t, certificateCheck(writeCert("expiring.crt", now.Add(-time.Hour), now.Add(time.Hour))).check(), "certificate expires at")
+	require.ErrorContains(t, certificateCheck(writeCert("future.crt", now.Add(time.Hour), now.Add(30*24*time.Hour))).check(), "certificate is not valid before")
+	require.ErrorContains(t, certificateCheck(filepath.Join(dir, "missing.crt")).check(), "cannot read certificate")
+}
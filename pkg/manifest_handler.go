@@ -1,59 +1,174 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 
-	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/sirupsen/logrus"
 )
 
 var mlog = logrus.WithField("module", "manifest")
 
-func manifestHandler(cfg *Config) http.HandlerFunc {
+// pluginManifest is the plugin manifest with the patches of the enabled features applied.
+type pluginManifest struct {
+	features []enabledFeature
+	// status of the features by name
+	status map[string]*featureStatus
+	data   []byte
+	// err is set if the manifest could not be loaded
+	err error
+}
+
+// loadManifest reads the base manifest and applies the patches of the enabled features in order.
+// A missing base manifest is reported by the readiness check, errors of patches are returned.
+func loadManifest(cfg *Config, features []enabledFeature, pluginConfig *PluginConfig) (*pluginManifest, error) {
+	manifest := &pluginManifest{features: features, status: map[string]*featureStatus{}}
+	for _, feature := range features {
+		status := &featureStatus{Enabled: true, Known: feature.known, Patches: []string{}, Extensions: []string{}}
+		for _, patch := range feature.patches {
+			status.Patches = append(status.Patches, filepath.Base(patch.file))
+		}
+		manifest.status[feature.name] = status
+	}
+	setError := func(err error) {
+		manifest.err = err
+		for _, status := range manifest.status {
+			status.Error = fmt.Sprintf("patches not applied: %v", err)
+		}
+	}
+
 	baseManifestData, err := os.ReadFile(filepath.Join(cfg.StaticPath, "plugin-manifest.json"))
 	if err != nil {
 		mlog.WithError(err).Error("cannot read base manifest file")
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		})
+		setError(err)
+		return manifest, nil
+	}
+
+	data, err := newManifestTemplateData(baseManifestData, features, pluginConfig)
+	if err != nil {
+		setError(err)
+		return manifest, nil
 	}
 
 	patchedManifest := baseManifestData
+	for _, feature := range features {
+		before := manifestExtensions(patchedManifest)
+		for _, patch := range feature.patches {
+			patchedManifest, err = patch.apply(patchedManifest, data)
+			if err != nil {
+				return nil, &featurePatchError{feature: feature.name, file: patch.file, err: err}
+			}
+			mlog.Debugf("applied patch %s of feature '%s'", patch.file, feature.name)
+		}
 
-	for k := range cfg.Features {
-		patchedManifest = patchManifest(patchedManifest, filepath.Join(cfg.ConfigPath, fmt.Sprintf("%s.patch.json", k)))
+		status := manifest.status[feature.name]
+		status.Applied = true
+		for _, extension := range manifestExtensions(patchedManifest) {
+			if !slices.Contains(before, extension) && !slices.Contains(status.Extensions, extension.id) {
+				status.Extensions = append(status.Extensions, extension.id)
+			}
+		}
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-		w.Header().Set("Expires", "0")
+	manifest.data = patchedManifest
+	return manifest, nil
+}
 
-		w.Write(patchedManifest)
-	})
+func newManifestTemplateData(baseManifest []byte, features []enabledFeature, pluginConfig *PluginConfig) (manifestTemplateData, error) {
+	var base struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(baseManifest, &base); err != nil {
+		return manifestTemplateData{}, fmt.Errorf("invalid base manifest: %w", err)
+	}
+
+	data := manifestTemplateData{
+		PluginName:    base.Name,
+		I18nNamespace: "plugin__" + base.Name,
+		Features:      []string{},
+		Instances:     []manifestTemplateInstance{},
+		Datasources:   []string{},
+	}
+	for _, feature := range features {
+		data.Features = append(data.Features, feature.name)
+	}
+	if pluginConfig != nil {
+		for _, instance := range pluginConfig.Instances {
+			data.Instances = append(data.Instances, manifestTemplateInstance{Namespace: instance.Namespace, Name: instance.Name})
+		}
+		for _, ds := range pluginConfig.Datasources {
+			data.Datasources = append(data.Datasources, ds.Name)
+		}
+	}
+	return data, nil
 }
 
-func patchManifest(originalData []byte, patchFilePath string) []byte {
-	patchData, err := os.ReadFile(patchFilePath)
-	if err != nil {
-		mlog.WithField("reason", err).Warnf("cannot read patch file %s", patchFilePath)
-		return originalData
+// manifestExtension is an extension of the manifest, identified by its key, or by its type if it has no key.
+type manifestExtension struct {
+	id  string
+	raw string
+}
+
+func manifestExtensions(manifest []byte) []manifestExtension {
+	var doc struct {
+		Extensions []json.RawMessage `json:"extensions"`
+	}
+	if err := json.Unmarshal(manifest, &doc); err != nil {
+		return nil
 	}
 
-	patch, err := jsonpatch.DecodePatch(patchData)
-	if err != nil {
-		mlog.WithField("reason", err).Warnf("cannot decode patch data %s", patchData)
-		return originalData
+	extensions := []manifestExtension{}
+	for _, raw := range doc.Extensions {
+		var extension consoleExtension
+		if err := json.Unmarshal(raw, &extension); err != nil {
+			continue
+		}
+		id := extension.key()
+		if id == "" {
+			id = extension.Type
+		}
+		// compact the JSON, to compare extensions independent of the formatting
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, raw); err != nil {
+			continue
+		}
+		extensions = append(extensions, manifestExtension{id: id, raw: compacted.String()})
 	}
+	return extensions
+}
 
-	patchedManifest, err := patch.ApplyIndent(originalData, " ")
-	if err != nil {
-		mlog.WithError(err).Error("cannot patch base manifest file")
-		return originalData
+func manifestHandler(manifest *pluginManifest) http.HandlerFunc {
+	if manifest.err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, manifest.err.Error(), http.StatusInternalServerError)
+		})
 	}
 
-	return patchedManifest
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Expires", "0")
+
+		w.Write(manifest.data)
+	})
+}
+
+// featuresHandler serves the status of the enabled features, to diagnose missing extensions from the browser.
+func featuresHandler(manifest *pluginManifest) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonFeatures, err := json.Marshal(manifest.status)
+		if err != nil {
+			log.WithError(err).Error("cannot marshall features")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonFeatures)
+	})
 }
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// patchFormat is a format of the manifest patches of a feature.
+type patchFormat string
+
+// The patches of a feature are applied in this order, if the files exist.
+const (
+	// list of console extensions, which are appended to the manifest
+	// or replace the extension with the same type and id (or path)
+	patchFormatExtensions patchFormat = "extensions"
+	// RFC 7386 JSON Merge Patch
+	patchFormatMerge patchFormat = "merge"
+	// RFC 6902 JSON Patch
+	patchFormatJSONPatch patchFormat = "patch"
+)
+
+var patchFormats = []patchFormat{patchFormatExtensions, patchFormatMerge, patchFormatJSONPatch}
+
+// manifestPatch is a patch file of a feature. Patch files are Go templates, rendered with manifestTemplateData.
+type manifestPatch struct {
+	file     string
+	format   patchFormat
+	template *template.Template
+}
+
+// manifestTemplateData is the data available in the templates of manifest patches.
+type manifestTemplateData struct {
+	// PluginName is the name of the plugin in the base manifest
+	PluginName string
+	// I18nNamespace is the namespace of the translations of the plugin, e.g. plugin__distributed-tracing-console-plugin
+	I18nNamespace string
+	// Features contains the names of the enabled features
+	Features []string
+	// Instances contains the Tempo instances configured in the plugin configuration
+	Instances []manifestTemplateInstance
+	// Datasources contains the names of the static datasources in the plugin configuration
+	Datasources []string
+}
+
+type manifestTemplateInstance struct {
+	Namespace string
+	Name      string
+}
+
+// featurePatchError is an error of a patch of a feature. It is not caused by the environment,
+// therefore the server fails to start instead of serving an unexpected manifest.
+type featurePatchError struct {
+	feature string
+	file    string
+	err     error
+}
+
+func (e *featurePatchError) Error() string {
+	return fmt.Sprintf("cannot apply patch %s of feature '%s': %v", filepath.Base(e.file), e.feature, e.err)
+}
+
+func (e *featurePatchError) Unwrap() error {
+	return e.err
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. to insert a string with proper escaping: {{ json .PluginName }}
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// findManifestPatches returns the patch files of a feature in the config path. At least one patch file is required.
+func findManifestPatches(configPath string, feature string) ([]manifestPatch, error) {
+	patches := []manifestPatch{}
+	files := []string{}
+	for _, format := range patchFormats {
+		file := filepath.Join(configPath, fmt.Sprintf("%s.%s.json", feature, format))
+		files = append(files, filepath.Base(file))
+
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read patch of feature '%s': %w", feature, err)
+		}
+
+		tmpl, err := template.New(filepath.Base(file)).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid template in patch of feature '%s': %w", feature, err)
+		}
+		patches = append(patches, manifestPatch{file: file, format: format, template: tmpl})
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("feature '%s' has no patch, expected one of %s in %s", feature, strings.Join(files, ", "), configPath)
+	}
+	return patches, nil
+}
+
+// apply renders the template of the patch and applies it to the manifest.
+func (p *manifestPatch) apply(manifest []byte, data manifestTemplateData) ([]byte, error) {
+	var rendered bytes.Buffer
+	if err := p.template.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+
+	switch p.format {
+	case patchFormatExtensions:
+		return appendExtensions(manifest, rendered.Bytes())
+
+	case patchFormatMerge:
+		if !json.Valid(rendered.Bytes()) {
+			return nil, errors.New("invalid JSON merge patch")
+		}
+		patched, err := jsonpatch.MergePatch(manifest, rendered.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		return indentManifest(patched)
+
+	case patchFormatJSONPatch:
+		patch, err := jsonpatch.DecodePatch(rendered.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		return patch.ApplyIndent(manifest, " ")
+
+	default:
+		return nil, fmt.Errorf("unsupported patch format '%s'", p.format)
+	}
+}
+
+// consoleExtension contains the fields which identify a console extension.
+type consoleExtension struct {
+	Type       string `json:"type"`
+	Properties struct {
+		ID   string `json:"id"`
+		Path string `json:"path"`
+	} `json:"properties"`
+}
+
+// key identifies an extension by its type and id, or by its type and path for extensions without id (e.g. routes).
+// Extensions without id and path have no key.
+func (e consoleExtension) key() string {
+	switch {
+	case e.Properties.ID != "":
+		return e.Type + "#id=" + e.Properties.ID
+	case e.Properties.Path != "":
+		return e.Type + "#path=" + e.Properties.Path
+	default:
+		return ""
+	}
+}
+
+// appendExtensions appends a list of console extensions to the extensions of the manifest.
+// An extension with the same key as an existing extension replaces the existing extension.
+func appendExtensions(manifest []byte, patch []byte) ([]byte, error) {
+	var extensions []json.RawMessage
+	if err := json.Unmarshal(patch, &extensions); err != nil {
+		return nil, fmt.Errorf("invalid list of extensions: %w", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(manifest, &doc); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	existing := []json.RawMessage{}
+	if raw, ok := doc["extensions"]; ok {
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return nil, fmt.Errorf("invalid extensions in manifest: %w", err)
+		}
+	}
+
+	index := map[string]int{}
+	for i, raw := range existing {
+		var extension consoleExtension
+		if err := json.Unmarshal(raw, &extension); err == nil && extension.key() != "" {
+			index[extension.key()] = i
+		}
+	}
+
+	for i, raw := range extensions {
+		var extension consoleExtension
+		if err := json.Unmarshal(raw, &extension); err != nil || extension.Type == "" {
+			return nil, fmt.Errorf("extension %d has no type", i)
+		}
+
+		key := extension.key()
+		if j, ok := index[key]; ok && key != "" {
+			existing[j] = raw
+			continue
+		}
+		if key != "" {
+			index[key] = len(existing)
+		}
+		existing = append(existing, raw)
+	}
+
+	data, err := json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+	doc["extensions"] = data
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return indentManifest(patched)
+}
+
+// indentManifest formats the manifest like the JSON patches applied with ApplyIndent.
+func indentManifest(manifest []byte) ([]byte, error) {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, manifest, "", " "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+)
+
+const testBaseManifest = `{
+ "name": "distributed-tracing-console-plugin",
+ "extensions": [
+  {"type": "console.navigation/href", "properties": {"id": "distributed-tracing", "name": "Traces"}},
+  {"type": "console.page/route", "properties": {"path": "/observe/traces"}}
+ ]
+}`
+
+func TestAppendExtensions(t *testing.T) {
+	patched, err := appendExtensions([]byte(testBaseManifest), []byte(`[
+		{"type": "console.navigation/href", "properties": {"id": "distributed-tracing", "name": "Tracing"}},
+		{"type": "console.page/route", "properties": {"path": "/observe/traces/:traceId"}},
+		{"type": "console.flag", "properties": {"handler": {"$codeRef": "flag"}}}
+	]`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"name": "distributed-tracing-console-plugin",
+		"extensions": [
+			{"type": "console.navigation/href", "properties": {"id": "distributed-tracing", "name": "Tracing"}},
+			{"type": "console.page/route", "properties": {"path": "/observe/traces"}},
+			{"type": "console.page/route", "properties": {"path": "/observe/traces/:traceId"}},
+			{"type": "console.flag", "properties": {"handler": {"$codeRef": "flag"}}}
+		]
+	}`, string(patched))
+
+	_, err = appendExtensions([]byte(testBaseManifest), []byte(`[{"properties": {}}]`))
+	require.EqualError(t, err, "extension 0 has no type")
+}
+
+func TestLoadManifestPatchFormats(t *testing.T) {
+	staticPath := t.TempDir()
+	configPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(staticPath, "plugin-manifest.json"), []byte(testBaseManifest), 0600))
+	writeFile := func(name string, data string) {
+		require.NoError(t, os.WriteFile(filepath.Join(configPath, name), []byte(data), 0600))
+	}
+
+	// the patches of a feature are applied in the order extensions, merge, patch
+	writeFile("tempo.extensions.json", `[
+		{{- range $i, $ds := .Datasources }}{{ if $i }},{{ end }}
+		{"type": "console.page/route", "properties": {"path": {{ json (printf "/observe/traces/%s" $ds) }}}}
+		{{- end }}
+	]`)
+	writeFile("tempo.merge.json", `{"i18nNamespace": {{ json .I18nNamespace }}, "removed": null}`)
+	writeFile("tempo.patch.json", `[{"op": "add", "path": "/extensions/3/properties/exact", "value": true}]`)
+
+	cfg := &Config{StaticPath: staticPath, ConfigPath: configPath, Features: map[string]bool{"tempo": true}}
+	features, err := resolveFeatures(cfg)
+	require.NoError(t, err)
+	require.Len(t, features[0].patches, 3)
+
+	pluginConfig := &PluginConfig{Datasources: []api.StaticDatasource{{Name: "dev"}, {Name: "prod"}}}
+	manifest, err := loadManifest(cfg, features, pluginConfig)
+	require.NoError(t, err)
+	require.NoError(t, manifest.err)
+	require.JSONEq(t, `{
+		"name": "distributed-tracing-console-plugin",
+		"i18nNamespace": "plugin__distributed-tracing-console-plugin",
+		"extensions": [
+			{"type": "console.navigation/href", "properties": {"id": "distributed-tracing", "name": "Traces"}},
+			{"type": "console.page/route", "properties": {"path": "/observe/traces"}},
+			{"type": "console.page/route", "properties": {"path": "/observe/traces/dev"}},
+			{"type": "console.page/route", "properties": {"path": "/observe/traces/prod", "exact": true}}
+		]
+	}`, string(manifest.data))
+
+	rr := httptest.NewRecorder()
+	featuresHandler(manifest)(rr, httptest.NewRequest("GET", "/features", nil))
+	require.JSONEq(t, `{
+		"tempo": {
+			"enabled": true,
+			"known": false,
+			"patches": ["tempo.extensions.json", "tempo.merge.json", "tempo.patch.json"],
+			"applied": true,
+			"extensions": ["console.page/route#path=/observe/traces/dev", "console.page/route#path=/observe/traces/prod"]
+		}
+	}`, rr.Body.String())
+
+	// patches which cannot be applied are returned as errors, to fail the startup
+	writeFile("tempo.merge.json", `{"name": {{ .Unknown }}}`)
+	features, err = resolveFeatures(cfg)
+	require.NoError(t, err)
+	_, err = loadManifest(cfg, features, pluginConfig)
+	var patchErr *featurePatchError
+	require.True(t, errors.As(err, &patchErr))
+	require.Equal(t, "tempo", patchErr.feature)
+	require.ErrorContains(t, err, "cannot apply patch tempo.merge.json of feature 'tempo'")
+
+	// a missing base manifest is not an error of the features
+	cfg.StaticPath = t.TempDir()
+	manifest, err = loadManifest(cfg, features, pluginConfig)
+	require.NoError(t, err)
+	require.ErrorIs(t, manifest.err, os.ErrNotExist)
+	require.False(t, manifest.status["tempo"].Applied)
+	require.Contains(t, manifest.status["tempo"].Error, "patches not applied")
+}
+
+func TestFindManifestPatchesInvalidTemplate(t *testing.T) {
+	configPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configPath, "tempo.merge.json"), []byte(`{"name": {{ .PluginName }`), 0600))
+
+	_, err := findManifestPatches(configPath, "tempo")
+	require.ErrorContains(t, err, "invalid template in patch of feature 'tempo'")
+}
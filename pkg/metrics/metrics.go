@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "distributed_tracing_console_plugin"
+
+// Registry contains all metrics of the plugin backend.
+// A dedicated registry is used instead of the global default registry,
+// to avoid exporting the metrics registered by dependencies.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	ProxyRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_requests_total",
+		Help:      "Number of proxied requests by Tempo instance, tenant and upstream status code.",
+	}, []string{"namespace", "name", "tenant", "code"})
+
+	ProxyRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_request_duration_seconds",
+		Help:      "Duration of proxied requests by Tempo instance and tenant.",
+		// trace searches can take much longer than the requests to the plugin backend
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"namespace", "name", "tenant"})
+
+	ProxyCacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_cache_hits_total",
+		Help:      "Number of requests which used a cached proxy.",
+	})
+
+	ProxyCacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_cache_misses_total",
+		Help:      "Number of requests which created a new proxy.",
+	})
+
+	ProxyCacheCoalescedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_cache_coalesced_total",
+		Help:      "Number of requests which used a proxy created by a concurrent request.",
+	})
+
+	ProxyCacheEvictionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_cache_evictions_total",
+		Help:      "Number of proxies removed from the cache, because the cache was full or the Tempo instance changed.",
+	})
+
+	ProxyRateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_rate_limited_total",
+		Help:      "Number of proxied requests rejected by scope (user or tenant) and reason (rate or concurrency).",
+	}, []string{"scope", "reason"})
+
+	TraceCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trace_cache_requests_total",
+		Help:      "Number of trace-by-ID lookups by cache result (hit, miss or bypass).",
+	}, []string{"result"})
+
+	TraceCacheSizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "trace_cache_size_bytes",
+		Help:      "Total size of the cached trace-by-ID responses.",
+	})
+
+	TraceCacheEvictionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trace_cache_evictions_total",
+		Help:      "Number of traces removed from the cache, because they expired, the cache was full or the Tempo instance changed.",
+	})
+
+	KubernetesListDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kubernetes_list_duration_seconds",
+		Help:      "Duration of list requests to the Kubernetes API by resource.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"resource"})
+
+	KubernetesListErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kubernetes_list_errors_total",
+		Help:      "Number of failed list requests to the Kubernetes API by resource.",
+	}, []string{"resource"})
+
+	TLSCertificateReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tls_certificate_reloads_total",
+		Help:      "Number of reloaded TLS certificates, by certificate (serving or proxy).",
+	}, []string{"certificate"})
+
+	PluginConfigReloadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_config_reloads_total",
+		Help:      "Number of times a changed plugin configuration file was loaded.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		ProxyRequestsTotal,
+		ProxyRequestDuration,
+		ProxyCacheHitsTotal,
+		ProxyCacheMissesTotal,
+		ProxyCacheCoalescedTotal,
+		ProxyCacheEvictionsTotal,
+		ProxyRateLimitedTotal,
+		TraceCacheRequestsTotal,
+		TraceCacheSizeBytes,
+		TraceCacheEvictionsTotal,
+		KubernetesListDuration,
+		KubernetesListErrorsTotal,
+		TLSCertificateReloadsTotal,
+		PluginConfigReloadsTotal,
+	)
+}
+
+// Handler serves the metrics of the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records the number and duration of requests per route.
+// The path template of the route is used as label, to keep the cardinality bounded.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := NewStatusRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		HTTPRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		HTTPRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(recorder.Status())).Inc()
+	})
+}
+
+// StatusRecorder is a http.ResponseWriter which records the status code of the response,
+// and optionally the body.
+type StatusRecorder struct {
+	http.ResponseWriter
+	status int
+
+	// the body is recorded up to bodyLimit bytes, if bodyLimit is set
+	bodyLimit    int
+	body         []byte
+	bodyTooLarge bool
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w}
+}
+
+func (r *StatusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *StatusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.bodyLimit > 0 && !r.bodyTooLarge {
+		if len(r.body)+len(b) > r.bodyLimit {
+			r.bodyTooLarge = true
+			r.body = nil
+		} else {
+			r.body = append(r.body, b...)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// RecordBody records the body of the response, up to limit bytes.
+func (r *StatusRecorder) RecordBody(limit int) {
+	r.bodyLimit = limit
+}
+
+// Body returns the recorded body. It returns false if the body was not recorded or exceeded the limit.
+func (r *StatusRecorder) Body() ([]byte, bool) {
+	return r.body, r.bodyLimit > 0 && !r.bodyTooLarge
+}
+
+// Unwrap allows http.ResponseController to flush the underlying response writer.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status returns the recorded status code, or 200 if no status was written.
+func (r *StatusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
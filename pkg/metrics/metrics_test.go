@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.Path("/api/v1/{name}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	router.Path("/metrics").Handler(Handler())
+
+	for _, path := range []string{"/api/v1/a", "/api/v1/b"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	// the route template is used as label instead of the path
+	require.Equal(t, 2.0, testutil.ToFloat64(HTTPRequestsTotal.WithLabelValues("/api/v1/{name}", "GET", "418")))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, strings.Contains(w.Body.String(), `distributed_tracing_console_plugin_http_requests_total{code="418",method="GET",route="/api/v1/{name}"} 2`))
+}
+
+func TestStatusRecorder(t *testing.T) {
+	recorder := NewStatusRecorder(httptest.NewRecorder())
+	require.Equal(t, http.StatusOK, recorder.Status())
+
+	recorder.WriteHeader(http.StatusBadGateway)
+	recorder.WriteHeader(http.StatusOK)
+	require.Equal(t, http.StatusBadGateway, recorder.Status())
+
+	// the underlying response writer can be flushed
+	require.NoError(t, http.NewResponseController(recorder).Flush())
+
+	_, ok := recorder.Body()
+	require.False(t, ok, "the body is only recorded if requested")
+
+	recorder = NewStatusRecorder(httptest.NewRecorder())
+	recorder.RecordBody(8)
+	recorder.Write([]byte("trace"))
+	body, ok := recorder.Body()
+	require.True(t, ok)
+	require.Equal(t, "trace", string(body))
+
+	recorder.Write([]byte("-too-large"))
+	_, ok = recorder.Body()
+	require.False(t, ok)
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/metrics"
+)
+
+// timeout of requests if no plugin configuration is loaded
+const defaultTimeout = 30 * time.Second
+
+// Validate checks the plugin configuration, a configuration which fails the validation is not loaded.
+func (pluginConfig *PluginConfig) Validate() error {
+	if pluginConfig.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative, got %s", pluginConfig.Timeout)
+	}
+
+	for _, instance := range pluginConfig.Instances {
+		if instance.Namespace == "" || instance.Name == "" {
+			return errors.New("namespace and name of instances are required")
+		}
+		if instance.TLS != nil {
+			if err := instance.TLS.Validate(); err != nil {
+				return fmt.Errorf("invalid TLS config of instance %s/%s: %w", instance.Namespace, instance.Name, err)
+			}
+		}
+	}
+
+	if err := pluginConfig.Transport.Validate(); err != nil {
+		return err
+	}
+	if err := pluginConfig.RateLimits.Validate(); err != nil {
+		return err
+	}
+
+	for _, allowedPath := range pluginConfig.AllowedPaths {
+		if err := allowedPath.Validate(); err != nil {
+			return err
+		}
+	}
+
+	names := map[string]bool{}
+	for _, ds := range pluginConfig.Datasources {
+		if err := ds.Validate(); err != nil {
+			return err
+		}
+		if names[ds.Name] {
+			return fmt.Errorf("duplicate datasource name '%s'", ds.Name)
+		}
+		names[ds.Name] = true
+	}
+	return nil
+}
+
+// pluginConfigContent is a loaded plugin configuration.
+type pluginConfigContent struct {
+	// raw content of the file, to detect changes
+	data   []byte
+	config *PluginConfig
+	// JSON representation served to the frontend
+	json []byte
+}
+
+// dynamicPluginConfig loads the plugin configuration file, and reloads it whenever it changes.
+// A changed configuration is only applied if it is valid, otherwise the previous configuration is kept.
+type dynamicPluginConfig struct {
+	path    string
+	content atomic.Pointer[pluginConfigContent]
+}
+
+// newDynamicPluginConfig loads the plugin configuration. If the file does not exist,
+// the default configuration is used; if the file is invalid, an error is returned.
+func newDynamicPluginConfig(path string) (*dynamicPluginConfig, error) {
+	c := &dynamicPluginConfig{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.WithError(err).Warnf("cannot read config file, serving plugin with default configuration, tried %s", path)
+		return c, nil
+	}
+
+	content, err := parsePluginConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s:\n%w", path, err)
+	}
+	c.content.Store(content)
+	return c, nil
+}
+
+func parsePluginConfig(data []byte) (*pluginConfigContent, error) {
+	pluginConfig, err := decodePluginConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonPluginConfig, err := pluginConfig.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshall config data: %w", err)
+	}
+
+	return &pluginConfigContent{data: data, config: pluginConfig, json: jsonPluginConfig}, nil
+}
+
+// Config returns the current plugin configuration, or nil if no configuration is loaded.
+func (c *dynamicPluginConfig) Config() *PluginConfig {
+	if content := c.content.Load(); content != nil {
+		return content.config
+	}
+	return nil
+}
+
+// Timeout returns the timeout of requests. A timeout of 0 disables the timeout.
+func (c *dynamicPluginConfig) Timeout() time.Duration {
+	if config := c.Config(); config != nil {
+		return config.Timeout
+	}
+	return defaultTimeout
+}
+
+// reload loads the configuration file, and replaces the current configuration if the file changed and is valid.
+func (c *dynamicPluginConfig) reload() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	existing := c.content.Load()
+	if existing != nil && bytes.Equal(existing.data, data) {
+		return nil
+	}
+
+	content, err := parsePluginConfig(data)
+	if err != nil {
+		return err
+	}
+
+	c.content.Store(content)
+	if existing != nil && existing.config != nil && !onlyRuntimeSettingsChanged(existing.config, content.config) {
+		log.Warn("changes of singleTenantInstances, instances, datasources, allowedPaths, transport and rateLimits are applied after a restart")
+	}
+	log.Infof("reloaded config file %s", c.path)
+	metrics.PluginConfigReloadsTotal.Inc()
+	return nil
+}
+
+// onlyRuntimeSettingsChanged returns true if only the settings which are applied without a restart changed.
+func onlyRuntimeSettingsChanged(a *PluginConfig, b *PluginConfig) bool {
+	a2, b2 := *a, *b
+	a2.Timeout, b2.Timeout = 0, 0
+	aData, errA := yaml.Marshal(a2)
+	bData, errB := yaml.Marshal(b2)
+	return errA == nil && errB == nil && bytes.Equal(aData, bData)
+}
+
+// Run watches the configuration file until the context is cancelled. Like the watcher of the serving
+// certificate, the file is also checked periodically in case the watch fails, and the watch is restarted
+// if the file is removed or renamed, e.g. when the symlinks of a mounted ConfigMap are swapped.
+func (c *dynamicPluginConfig) Run(ctx context.Context) {
+	if c.path == "" {
+		return
+	}
+
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.watchFile(ctx); err != nil {
+			log.WithError(err).Error("failed to watch config file, will retry later")
+		}
+	}, time.Minute)
+}
+
+func (c *dynamicPluginConfig) watchFile(ctx context.Context) error {
+	// check the file here to reload it periodically even if the watch fails
+	c.reloadAndLog()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating fsnotify watcher: %w", err)
+	}
+	defer w.Close()
+
+	if err := w.Add(c.path); err != nil {
+		return fmt.Errorf("error adding watch for file %s: %w", c.path, err)
+	}
+	// check again in case the file was updated before the watch started
+	c.reloadAndLog()
+
+	for {
+		select {
+		case e := <-w.Events:
+			if err := c.handleWatchEvent(e, w); err != nil {
+				return err
+			}
+		case err := <-w.Errors:
+			return fmt.Errorf("received fsnotify error: %w", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handleWatchEvent reloads the configuration, and restarts the watch on Remove and Rename events.
+func (c *dynamicPluginConfig) handleWatchEvent(e fsnotify.Event, w *fsnotify.Watcher) error {
+	// reload after restarting the watch, to not miss any changes
+	defer c.reloadAndLog()
+	if !e.Has(fsnotify.Remove) && !e.Has(fsnotify.Rename) {
+		return nil
+	}
+	if err := w.Remove(e.Name); err != nil {
+		log.WithError(err).Debugf("failed to remove watch for file %s, it may have been deleted", e.Name)
+	}
+	if err := w.Add(e.Name); err != nil {
+		return fmt.Errorf("error adding watch for file %s: %w", e.Name, err)
+	}
+	return nil
+}
+
+func (c *dynamicPluginConfig) reloadAndLog() {
+	err := c.reload()
+	if errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).Debugf("cannot reload config file %s", c.path)
+	} else if err != nil {
+		log.WithError(err).Errorf("cannot reload config file %s, keeping the previous configuration", c.path)
+	}
+}
+
+// configHandler serves the current plugin configuration to the frontend.
+func (c *dynamicPluginConfig) configHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := c.content.Load()
+		if content == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content.json)
+	})
+}
+
+// timeoutMiddleware applies the timeout of the current plugin configuration to each request:
+// the read and write deadlines of the connection are set, and the context of the request is cancelled.
+func timeoutMiddleware(pluginConfig *dynamicPluginConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := pluginConfig.Timeout()
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		deadline := time.Now().Add(timeout)
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.WithError(err).Debug("cannot set read deadline")
+		}
+		if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.WithError(err).Debug("cannot set write deadline")
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is an error at a position of the plugin configuration file.
+type ConfigError struct {
+	// Line and Column are 1-based, Column is 0 if unknown.
+	Line   int
+	Column int
+	// Field is the path of the field, e.g. datasources[0].tls.caFile
+	Field   string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "line %d", e.Line)
+	if e.Column > 0 {
+		fmt.Fprintf(&b, ", column %d", e.Column)
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&b, ": %s", e.Field)
+	}
+	fmt.Fprintf(&b, ": %s", e.Message)
+	return b.String()
+}
+
+// errors of the yaml package start with the line number, e.g. "yaml: line 3: did not find expected key"
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// decodePluginConfig decodes and validates the plugin configuration.
+// Unknown fields and values of the wrong type are rejected, and reported with their position in the file.
+func decodePluginConfig(data []byte) (*PluginConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, yamlError(err)
+	}
+
+	var pluginConfig PluginConfig
+	if len(root.Content) == 0 {
+		// empty file
+		return &pluginConfig, nil
+	}
+
+	if errs := checkNode(root.Content[0], reflect.TypeFor[PluginConfig](), ""); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// all fields are checked above, decode with known fields as a safeguard
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&pluginConfig); err != nil && !errors.Is(err, io.EOF) {
+		return nil, yamlError(err)
+	}
+
+	if err := pluginConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config data: %w", err)
+	}
+	return &pluginConfig, nil
+}
+
+// checkNode checks that a YAML node can be decoded into a value of type t, and returns all errors.
+func checkNode(node *yaml.Node, t reflect.Type, field string) []error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return []error{nodeError(node, field, "expected a mapping")}
+		}
+		fields := yamlFields(t)
+		var errs []error
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, nodeError(key, field, fmt.Sprintf("unknown field '%s'", key.Value)))
+				continue
+			}
+			errs = append(errs, checkNode(value, fieldType, joinField(field, key.Value))...)
+		}
+		return errs
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return []error{nodeError(node, field, "expected a sequence")}
+		}
+		var errs []error
+		for i, item := range node.Content {
+			errs = append(errs, checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return errs
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return []error{nodeError(node, field, "expected a mapping")}
+		}
+		var errs []error
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			errs = append(errs, checkNode(value, t.Elem(), joinField(field, key.Value))...)
+		}
+		return errs
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			return []error{nodeError(node, field, fmt.Sprintf("expected a %s value", t.Kind()))}
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			message := err.Error()
+			var typeErr *yaml.TypeError
+			if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+				message = typeErr.Errors[0]
+			}
+			if match := yamlErrorLine.FindStringSubmatch(message); match != nil {
+				message = match[2]
+			}
+			return []error{nodeError(node, field, message)}
+		}
+		return nil
+	}
+}
+
+// yamlFields returns the types of the fields of a struct by their YAML name.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func joinField(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func nodeError(node *yaml.Node, field string, message string) error {
+	return &ConfigError{Line: node.Line, Column: node.Column, Field: field, Message: message}
+}
+
+// yamlError converts the syntax and type errors of the yaml package, which only contain the line number.
+func yamlError(err error) error {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	var errs []error
+	for _, message := range messages {
+		match := yamlErrorLine.FindStringSubmatch(message)
+		if match == nil {
+			errs = append(errs, errors.New(message))
+			continue
+		}
+		line, _ := strconv.Atoi(match[1])
+		errs = append(errs, &ConfigError{Line: line, Message: match[2]})
+	}
+	return errors.Join(errs...)
+}
+
+// ValidatePluginConfigFile reads and validates a plugin configuration file.
+// All errors found in the file are returned, joined by errors.Join.
+func ValidatePluginConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = decodePluginConfig(data)
+	return err
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePluginConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errs   []string
+	}{
+		{
+			name:   "empty",
+			config: "",
+		},
+		{
+			name: "valid",
+			config: `timeout: 30s
+singleTenantInstances: true
+instances:
+  - namespace: tracing
+    name: simplest
+    tenantTokenFiles:
+      dev: /var/run/secrets/dev/token
+allowedPaths:
+  - path: /api/search
+    methods: [GET, POST]
+rateLimits:
+  user:
+    requestsPerSecond: 0.5
+    burst: 5
+    maxInFlight: 4
+transport:
+  maxIdleConnsPerHost: 64
+  responseHeaderTimeout: 2m
+  disableHTTP2: true
+datasources:
+  - name: tempo
+    url: https://tempo.example.com:3200
+    tls:
+      ca:
+        kind: ConfigMap
+        namespace: tracing
+        name: tempo-ca
+`,
+		},
+		{
+			name:   "unknown field",
+			config: "logsLimit: 100\ntimeout: 30s\n",
+			errs:   []string{"line 1, column 1: unknown field 'logsLimit'"},
+		},
+		{
+			name: "multiple errors in nested fields",
+			config: `timeout: 30
+singleTenantInstances: yes please
+datasources:
+  - name: tempo
+    url: https://tempo.example.com:3200
+    tls:
+      caFil: /etc/ca.crt
+`,
+			errs: []string{
+				"line 1, column 10: timeout: cannot unmarshal !!int `30` into time.Duration",
+				"line 2, column 24: singleTenantInstances: cannot unmarshal !!str `yes please` into bool",
+				"line 7, column 7: datasources[0].tls: unknown field 'caFil'",
+			},
+		},
+		{
+			name:   "wrong kind",
+			config: "datasources:\n  name: tempo\n",
+			errs:   []string{"line 2, column 3: datasources: expected a sequence"},
+		},
+		{
+			name:   "syntax error",
+			config: "timeout: 30s\n  singleTenantInstances: true\n",
+			errs:   []string{"line 2: mapping values are not allowed in this context"},
+		},
+		{
+			name:   "invalid allowed path",
+			config: "allowedPaths:\n  - path: api/search\n",
+			errs:   []string{"invalid config data: allowed path 'api/search' must start with /"},
+		},
+		{
+			name:   "semantic error",
+			config: "datasources:\n  - name: tempo\n    url: https://tempo:3200\n  - name: tempo\n    url: https://tempo:3200\n",
+			errs:   []string{"invalid config data: duplicate datasource name 'tempo'"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodePluginConfig([]byte(tt.config))
+			if len(tt.errs) == 0 {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, strings.Join(tt.errs, "\n"))
+			}
+		})
+	}
+}
+
+// TestPluginConfigSchema checks that the published JSON Schema contains the same fields as PluginConfig.
+func TestPluginConfigSchema(t *testing.T) {
+	data, err := os.ReadFile("../schema/plugin-config.schema.json")
+	require.NoError(t, err)
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(data, &schema))
+	defs := schema["$defs"].(map[string]any)
+
+	var checkSchema func(field string, s map[string]any, typ reflect.Type)
+	checkSchema = func(field string, s map[string]any, typ reflect.Type) {
+		if ref, ok := s["$ref"].(string); ok {
+			s = defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		}
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+
+		switch typ.Kind() {
+		case reflect.Struct:
+			properties := s["properties"].(map[string]any)
+			fields := yamlFields(typ)
+			require.ElementsMatch(t, slices.Collect(maps.Keys(fields)), slices.Collect(maps.Keys(properties)), "fields of %s", field)
+			for name, fieldType := range fields {
+				checkSchema(joinField(field, name), properties[name].(map[string]any), fieldType)
+			}
+		case reflect.Slice:
+			checkSchema(field+"[]", s["items"].(map[string]any), typ.Elem())
+		case reflect.Map:
+			checkSchema(field+"{}", s["additionalProperties"].(map[string]any), typ.Elem())
+		}
+	}
+	checkSchema("", schema, reflect.TypeFor[PluginConfig]())
+}
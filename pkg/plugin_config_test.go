@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func getConfig(t *testing.T, c *dynamicPluginConfig) (int, string) {
+	rr := httptest.NewRecorder()
+	c.configHandler()(rr, httptest.NewRequest("GET", "/config", nil))
+	return rr.Code, rr.Body.String()
+}
+
+func TestDynamicPluginConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	// default configuration if the file does not exist
+	c, err := newDynamicPluginConfig(path)
+	require.NoError(t, err)
+	require.Nil(t, c.Config())
+	require.Equal(t, defaultTimeout, c.Timeout())
+	code, body := getConfig(t, c)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "{}", body)
+
+	require.NoError(t, os.WriteFile(path, []byte("timeout: 10s\n"), 0600))
+	require.NoError(t, c.reload())
+	require.Equal(t, 10*time.Second, c.Timeout())
+	_, body = getConfig(t, c)
+	require.JSONEq(t, `{"timeout": 10}`, body)
+
+	// invalid configurations are rejected, and the previous configuration is kept
+	require.NoError(t, os.WriteFile(path, []byte("timeout: -1s\n"), 0600))
+	require.ErrorContains(t, c.reload(), "timeout must not be negative")
+	require.NoError(t, os.WriteFile(path, []byte("datasources:\n  - name: tempo\n    url: tempo:3200\n"), 0600))
+	require.ErrorContains(t, c.reload(), "invalid URL of datasource 'tempo'")
+	require.Equal(t, 10*time.Second, c.Timeout())
+
+	// a removed file keeps the previous configuration
+	require.NoError(t, os.Remove(path))
+	require.Error(t, c.reload())
+	require.Equal(t, 10*time.Second, c.Timeout())
+}
+
+func TestDynamicPluginConfigInvalidAtStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("timeout: 5s\nlogsLimit: 100\n"), 0600))
+
+	_, err := newDynamicPluginConfig(path)
+	require.ErrorContains(t, err, "line 2, column 1: unknown field 'logsLimit'")
+}
+
+func TestDynamicPluginConfigWatch(t *testing.T) {
+	// simulate the atomic update of a mounted ConfigMap, which swaps a symlink to a new directory
+	dir := t.TempDir()
+	for _, data := range []string{"..data_1", "..data_2"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, data), 0700))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "..data_1", "config.yaml"), []byte("timeout: 10s\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "..data_2", "config.yaml"), []byte("timeout: 20s\n"), 0600))
+	require.NoError(t, os.Symlink("..data_1", filepath.Join(dir, "..data")))
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.Symlink(filepath.Join("..data", "config.yaml"), path))
+
+	c, err := newDynamicPluginConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, c.Timeout())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Run(ctx)
+
+	require.NoError(t, os.Symlink("..data_2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "..data_1")))
+
+	require.Eventually(t, func() bool {
+		return c.Timeout() == 20*time.Second
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("timeout: 10s\n"), 0600))
+	c, err := newDynamicPluginConfig(path)
+	require.NoError(t, err)
+
+	var deadline time.Time
+	handler := timeoutMiddleware(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	require.WithinDuration(t, time.Now().Add(10*time.Second), deadline, time.Second)
+
+	// the timeout of a reloaded configuration applies to the next request
+	require.NoError(t, os.WriteFile(path, []byte("timeout: 0s\n"), 0600))
+	require.NoError(t, c.reload())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	require.True(t, deadline.IsZero())
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// AllowedPath is a path of the Tempo API which can be queried via the proxy.
+type AllowedPath struct {
+	// Path relative to the base URL of the Tempo API. A segment {name} matches any single segment, e.g. /api/traces/{id}
+	Path string `json:"path" yaml:"path"`
+	// Methods contains the allowed HTTP methods, GET if empty.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+}
+
+// DefaultAllowedPaths are the read-only query APIs of Tempo. All other APIs (e.g. /flush, /shutdown,
+// /api/overrides or the ingest endpoints) are rejected by the proxy.
+var DefaultAllowedPaths = []AllowedPath{
+	{Path: "/api/search"},
+	{Path: "/api/traces/{id}"},
+	{Path: "/api/v2/traces/{id}"},
+	{Path: "/api/search/tags"},
+	{Path: "/api/v2/search/tags"},
+	{Path: "/api/v2/search/tag/{tag}/values"},
+	{Path: "/api/metrics/query_range"},
+	{Path: "/api/echo"},
+	{Path: "/api/status/buildinfo"},
+}
+
+// Validate checks if the path is absolute and the methods are valid.
+func (p *AllowedPath) Validate() error {
+	if !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("allowed path '%s' must start with /", p.Path)
+	}
+	for _, segment := range strings.Split(p.Path, "/")[1:] {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("allowed path '%s' contains an empty or relative segment", p.Path)
+		}
+	}
+	for _, method := range p.Methods {
+		if method == "" || method != strings.ToUpper(method) || strings.ContainsAny(method, " \t/") {
+			return fmt.Errorf("invalid method '%s' of allowed path '%s'", method, p.Path)
+		}
+	}
+	return nil
+}
+
+// pathAllowlist matches requests against the allowed paths of the Tempo API.
+type pathAllowlist []allowedPathPattern
+
+type allowedPathPattern struct {
+	segments []string
+	methods  []string
+}
+
+func newPathAllowlist(paths []AllowedPath) pathAllowlist {
+	allowlist := pathAllowlist{}
+	for _, p := range paths {
+		methods := p.Methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		allowlist = append(allowlist, allowedPathPattern{
+			segments: strings.Split(strings.TrimPrefix(p.Path, "/"), "/"),
+			methods:  methods,
+		})
+	}
+	return allowlist
+}
+
+// allows checks if a request to the (unescaped) path of the Tempo API is allowed.
+// Paths with empty or relative segments are never allowed, because they could be resolved to another API by Tempo.
+func (l pathAllowlist) allows(method string, path string) bool {
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	for _, pattern := range l {
+		if pattern.matches(segments) && slices.Contains(pattern.methods, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *allowedPathPattern) matches(segments []string) bool {
+	if len(segments) != len(p.segments) {
+		return false
+	}
+	for i, segment := range p.segments {
+		isParam := strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+		if !isParam && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
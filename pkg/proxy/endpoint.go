@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// endpointSource describes how the endpoint of a Tempo instance was determined.
+type endpointSource string
+
+const (
+	// the endpoint was read from a Service object created by the Tempo operator
+	endpointSourceService endpointSource = "service"
+	// the endpoint was derived from the naming conventions of the Tempo operator
+	endpointSourceConvention endpointSource = "convention"
+)
+
+// endpoint is the address of the gateway or query frontend of a Tempo instance.
+type endpoint struct {
+	scheme string
+	// host and port, e.g. tempo-simplest-gateway.ns.svc:8080
+	host   string
+	source endpointSource
+}
+
+func (e endpoint) String() string {
+	return fmt.Sprintf("%s://%s", e.scheme, e.host)
+}
+
+// serviceRef identifies the Service of a Tempo component,
+// and the name and number of its port by the conventions of the Tempo operator.
+type serviceRef struct {
+	scheme string
+	// value of the app.kubernetes.io/name label
+	appName string
+	// value of the app.kubernetes.io/component label
+	component string
+	// name of the Service by convention
+	serviceName string
+	portName    string
+	port        int32
+}
+
+// serviceRefFor returns the Service which receives the queries of a Tempo instance:
+// the gateway for multi-tenant instances, and the query frontend for single-tenant instances.
+func serviceRefFor(tempo api.TempoResource) (serviceRef, error) {
+	switch tempo.Kind {
+	case api.KindTempoStack:
+		if !tempo.SingleTenant {
+			return serviceRef{
+				scheme:      "https",
+				appName:     "tempo",
+				component:   "gateway",
+				serviceName: DNSName(fmt.Sprintf("tempo-%s-gateway", tempo.Name)),
+				portName:    "public",
+				port:        8080,
+			}, nil
+		}
+		return serviceRef{
+			scheme:      "http",
+			appName:     "tempo",
+			component:   "query-frontend",
+			serviceName: DNSName(fmt.Sprintf("tempo-%s-query-frontend", tempo.Name)),
+			portName:    "http",
+			port:        3200,
+		}, nil
+
+	case api.KindTempoMonolithic:
+		if !tempo.SingleTenant {
+			return serviceRef{
+				scheme:      "https",
+				appName:     "tempo-monolithic",
+				component:   "gateway",
+				serviceName: DNSName(fmt.Sprintf("tempo-%s-gateway", tempo.Name)),
+				portName:    "public",
+				port:        8080,
+			}, nil
+		}
+		return serviceRef{
+			scheme:      "http",
+			appName:     "tempo-monolithic",
+			component:   "tempo",
+			serviceName: DNSName(fmt.Sprintf("tempo-%s", tempo.Name)),
+			portName:    "http",
+			port:        3200,
+		}, nil
+
+	default:
+		return serviceRef{}, fmt.Errorf("invalid Tempo resource with kind '%s'", tempo.Kind)
+	}
+}
+
+// resolveEndpoint returns the endpoint of a Tempo instance.
+// The endpoint is read from the Service created by the operator, selected by its labels.
+// If the Service cannot be found, the endpoint is derived from the naming conventions of the operator.
+func resolveEndpoint(ctx context.Context, k8sclient kubernetes.Interface, tempo api.TempoResource) (endpoint, error) {
+	ref, err := serviceRefFor(tempo)
+	if err != nil {
+		return endpoint{}, err
+	}
+
+	if k8sclient != nil {
+		e, err := lookupServiceEndpoint(ctx, k8sclient, tempo, ref)
+		if err == nil {
+			return e, nil
+		}
+		log.WithError(err).WithFields(logrus.Fields{"namespace": tempo.Namespace, "tempo": tempo.Name}).
+			Warnf("cannot discover %s service, falling back to %s", ref.component, ref.serviceName)
+	}
+
+	return endpoint{
+		scheme: ref.scheme,
+		host:   net.JoinHostPort(fmt.Sprintf("%s.%s.svc", ref.serviceName, tempo.Namespace), strconv.Itoa(int(ref.port))),
+		source: endpointSourceConvention,
+	}, nil
+}
+
+func lookupServiceEndpoint(ctx context.Context, k8sclient kubernetes.Interface, tempo api.TempoResource, ref serviceRef) (endpoint, error) {
+	selector := labels.SelectorFromSet(labels.Set{
+		"app.kubernetes.io/name":       ref.appName,
+		"app.kubernetes.io/instance":   tempo.Name,
+		"app.kubernetes.io/component":  ref.component,
+		"app.kubernetes.io/managed-by": "tempo-operator",
+	})
+	services, err := k8sclient.CoreV1().Services(tempo.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return endpoint{}, fmt.Errorf("cannot list services: %w", err)
+	}
+
+	// sort by name to select the same service if there are multiple candidates
+	sort.Slice(services.Items, func(i, j int) bool {
+		return services.Items[i].Name < services.Items[j].Name
+	})
+	for _, service := range services.Items {
+		// the headless discovery services resolve to the individual pods
+		if service.Spec.ClusterIP == corev1.ClusterIPNone {
+			continue
+		}
+		for _, port := range service.Spec.Ports {
+			if port.Name == ref.portName {
+				return endpoint{
+					scheme: ref.scheme,
+					host:   net.JoinHostPort(fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace), strconv.Itoa(int(port.Port))),
+					source: endpointSourceService,
+				}, nil
+			}
+		}
+	}
+	return endpoint{}, fmt.Errorf("no service with selector '%s' and port '%s' found", selector.String(), ref.portName)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/auth"
+)
+
+var (
+	errForbidden = errors.New("forbidden")
+	// errTempoNotFound is returned if the requested Tempo instance or datasource does not exist
+	errTempoNotFound = errors.New("tempo instance not found")
+	// errTenantNotFound is returned if the requested tenant is not a tenant of the Tempo instance
+	errTenantNotFound = errors.New("tenant not found")
+	// errTenantNotConfigured is returned if no token is configured for a tenant in static tenancy mode
+	errTenantNotConfigured = errors.New("tenant not configured")
+	// errTLSConfig is returned if the CA bundle or client certificate of a Tempo instance cannot be loaded
+	errTLSConfig = errors.New("invalid TLS config")
+)
+
+// writeError writes an error of the proxy in the JSON response format of the plugin backend.
+func writeError(w http.ResponseWriter, err error) {
+	code, errorType := classifyError(err)
+	api.WriteErrorResponse(w, code, errorType, fmt.Errorf("cannot proxy request: %w", err))
+}
+
+// classifyError returns the status code and error type of an error of the proxy,
+// including errors of the connection to Tempo.
+func classifyError(err error) (int, string) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized, api.ErrorTypeUnauthorized
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden, api.ErrorTypeForbidden
+	case errors.Is(err, errTempoNotFound):
+		return http.StatusNotFound, api.ErrorTypeTempoNotFound
+	case errors.Is(err, errTenantNotFound):
+		return http.StatusNotFound, api.ErrorTypeTenantNotFound
+	case errors.Is(err, errTenantNotConfigured):
+		return http.StatusInternalServerError, api.ErrorTypeTenantNotConfigured
+	case errors.Is(err, errTLSConfig):
+		return http.StatusInternalServerError, api.ErrorTypeTLSConfig
+	case errors.Is(err, context.Canceled):
+		return http.StatusBadGateway, api.ErrorTypeRequestCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, api.ErrorTypeGatewayTimeout
+	case isTLSError(err):
+		return http.StatusBadGateway, api.ErrorTypeGatewayTLS
+	case errors.As(err, &netErr):
+		return http.StatusBadGateway, api.ErrorTypeGatewayUnreachable
+	default:
+		return http.StatusInternalServerError, api.ErrorTypeInternal
+	}
+}
+
+// isTLSError checks if the TLS handshake with Tempo failed, e.g. because of an untrusted certificate.
+func isTLSError(err error) bool {
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordHeaderErr tls.RecordHeaderError
+	var opErr *net.OpError
+	return errors.As(err, &verificationErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &recordHeaderErr) ||
+		// TLS alerts of the server, e.g. if the client certificate was rejected
+		errors.As(err, &opErr) && opErr.Op == "remote error"
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+)
+
+// maxGatewayErrorSize limits the part of an error response of the gateway which is included in the error message.
+const maxGatewayErrorSize = 4096
+
+// serviceAccountFallbackTransport retries requests rejected by the gateway with the token of the service account of the plugin.
+// The console user was already authorized by the plugin before the request was forwarded.
+type serviceAccountFallbackTransport struct {
+	base      http.RoundTripper
+	tokenFile string
+}
+
+func (t *serviceAccountFallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !isGatewayAuthError(resp.StatusCode) {
+		return resp, err
+	}
+
+	// the body of the request was consumed by the first attempt
+	if req.Method != http.MethodGet && req.Method != http.MethodHead || req.Body != nil && req.Body != http.NoBody {
+		return resp, nil
+	}
+
+	token, err := readTokenFile(t.tokenFile)
+	if err != nil {
+		log.WithError(err).Warn("cannot read service account token, the response of the gateway is returned")
+		return resp, nil
+	}
+
+	log.WithField("status", resp.StatusCode).Debugf("gateway rejected the token of the user, retrying %s with the service account token", req.URL.Path)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxGatewayErrorSize))
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(retry)
+}
+
+func isGatewayAuthError(code int) bool {
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+// translateGatewayAuthError replaces 401 and 403 responses of the gateway, which are plain text or HTML,
+// with an error in the JSON response format of the plugin backend.
+func translateGatewayAuthError(resp *http.Response) error {
+	if !isGatewayAuthError(resp.StatusCode) {
+		return nil
+	}
+
+	message := http.StatusText(resp.StatusCode)
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.Header.Get("Content-Encoding") == "" && (mediaType == "" || mediaType == "text/plain") {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxGatewayErrorSize))
+		if err == nil && len(bytes.TrimSpace(body)) > 0 {
+			message = string(bytes.TrimSpace(body))
+		}
+	}
+	resp.Body.Close()
+
+	errorType := api.ErrorTypeGatewayUnauthorized
+	if resp.StatusCode == http.StatusForbidden {
+		errorType = api.ErrorTypeGatewayForbidden
+	}
+	data, err := json.Marshal(api.Response{
+		Status:    api.StatusError,
+		ErrorType: errorType,
+		Error:     fmt.Sprintf("the Tempo gateway rejected the request: %s", message),
+	})
+	if err != nil {
+		return err
+	}
+	log.WithField("status", resp.StatusCode).Warnf("the Tempo gateway rejected the request: %s", message)
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("WWW-Authenticate")
+	return nil
+}
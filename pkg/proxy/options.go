@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+)
+
+// Options contains the settings of the proxy.
+type Options struct {
+	// ServiceCAFile is the CA bundle used to verify the certificates of the Tempo gateways.
+	ServiceCAFile   string
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+	// ServiceAccountTokenFile is the token of the service account of the plugin,
+	// sent to gateways of instances with ServiceAccountFallback. Defaults to the token mounted into the pod.
+	ServiceAccountTokenFile string
+	// Instances contains settings for individual Tempo instances.
+	Instances []InstanceConfig
+	// Transport contains the connection settings to the Tempo instances.
+	Transport TransportConfig
+	// RateLimits limits the requests per user and per tenant.
+	RateLimits RateLimitConfig
+	// AllowedPaths are the paths of the Tempo API which can be queried. Defaults to DefaultAllowedPaths.
+	AllowedPaths []AllowedPath
+}
+
+// InstanceConfig contains the settings for a Tempo instance managed by the Tempo operator.
+type InstanceConfig struct {
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Name      string `json:"name" yaml:"name"`
+	// TokenFile is the path of a file containing the bearer token (e.g. an OIDC token)
+	// which is sent to the gateway of Tempo instances in static tenancy mode.
+	TokenFile string `json:"tokenFile,omitempty" yaml:"tokenFile,omitempty"`
+	// TenantTokenFiles overrides TokenFile for individual tenants.
+	TenantTokenFiles map[string]string `json:"tenantTokenFiles,omitempty" yaml:"tenantTokenFiles,omitempty"`
+	// ServiceAccountFallback retries read requests rejected by the gateway with the token of the service account of the plugin.
+	// The user is still authorized by the plugin, therefore the service account needs read access to all tenants of the instance.
+	ServiceAccountFallback bool `json:"serviceAccountFallback,omitempty" yaml:"serviceAccountFallback,omitempty"`
+	// TLS overrides the service CA bundle, e.g. for gateways with certificates signed by a custom CA.
+	TLS *api.TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// DefaultServiceAccountTokenFile is the token of the service account mounted into the pod.
+const DefaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func (opts *Options) serviceAccountTokenFile() string {
+	if opts.ServiceAccountTokenFile != "" {
+		return opts.ServiceAccountTokenFile
+	}
+	return DefaultServiceAccountTokenFile
+}
+
+func (opts *Options) allowedPaths() []AllowedPath {
+	if len(opts.AllowedPaths) > 0 {
+		return opts.AllowedPaths
+	}
+	return DefaultAllowedPaths
+}
+
+func (opts *Options) instanceConfig(namespace string, name string) (InstanceConfig, bool) {
+	for _, instance := range opts.Instances {
+		if instance.Namespace == namespace && instance.Name == name {
+			return instance, true
+		}
+	}
+	return InstanceConfig{}, false
+}
+
+// tokenFile returns the path of the file containing the token for a tenant.
+func (instance *InstanceConfig) tokenFile(tenant string) (string, error) {
+	if tokenFile, ok := instance.TenantTokenFiles[tenant]; ok {
+		return tokenFile, nil
+	}
+	if instance.TokenFile != "" {
+		return instance.TokenFile, nil
+	}
+	return "", fmt.Errorf("no token file configured for tenant '%s' of %s/%s", tenant, instance.Namespace, instance.Name)
+}
@@ -3,48 +3,91 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
-	"net"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
-	oscrypto "github.com/openshift/library-go/pkg/crypto"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/auth"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/metrics"
 	"github.com/sirupsen/logrus"
-	"k8s.io/client-go/dynamic"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/client-go/kubernetes"
 )
 
 var log = logrus.WithField("module", "proxy")
 
+// createProxyTimeout limits the time to discover the endpoint and load the TLS material of a Tempo instance.
+const createProxyTimeout = 30 * time.Second
+
 type ProxyHandler struct {
-	k8sclient       *dynamic.DynamicClient
-	serviceCAfile   string
-	tlsMinVersion   uint16
-	tlsCipherSuites []uint16
-	proxyCache      *lru.Cache[string, *httputil.ReverseProxy]
+	tempoCache *api.TempoCache
+	authorizer *auth.Authorizer
+	// k8sclient is used to discover the Services of Tempo instances
+	k8sclient  kubernetes.Interface
+	opts       Options
+	proxyCache *lru.Cache[string, *httputil.ReverseProxy]
+	// allowlist contains the APIs of Tempo which can be queried via the proxy
+	allowlist pathAllowlist
+	// traceCache contains the responses of trace-by-ID lookups
+	traceCache *traceCache
+	// rateLimiter limits the requests per user and per tenant
+	rateLimiter *rateLimiter
+
+	// proxyCacheGeneration is incremented whenever proxies are evicted because a Tempo resource changed.
+	// It prevents caching a proxy which was created from the previous state of the Tempo resource.
+	proxyCacheMu         sync.Mutex
+	proxyCacheGeneration uint64
+
+	// proxyGroup ensures that concurrent requests with the same cache key create a single proxy
+	proxyGroup singleflight.Group
+
+	// transports shared by the proxies to the same host, by host and TLS fingerprint
+	transportsMu sync.Mutex
+	transports   map[string]*pooledTransport
+
+	// fingerprints of the TLS material of Tempo instances, by namespace/name
+	tlsFingerprintsMu sync.Mutex
+	tlsFingerprints   map[string]string
 }
 
-func NewProxyHandler(k8sclient *dynamic.DynamicClient, serviceCAfile string, tlsMinVersion uint16, tlsCipherSuites []uint16) *ProxyHandler {
-	proxyCache, err := lru.New[string, *httputil.ReverseProxy](128)
+func NewProxyHandler(tempoCache *api.TempoCache, authorizer *auth.Authorizer, k8sclient kubernetes.Interface, opts Options) *ProxyHandler {
+	proxyCache, err := lru.NewWithEvict(128, func(string, *httputil.ReverseProxy) {
+		metrics.ProxyCacheEvictionsTotal.Inc()
+	})
 	if err != nil {
 		// the only error path of lru.New is size <= 0
 		panic(fmt.Errorf("cannot allocate LRU cache: %w", err))
 	}
 
-	return &ProxyHandler{
-		k8sclient:       k8sclient,
-		serviceCAfile:   serviceCAfile,
-		tlsMinVersion:   tlsMinVersion,
-		tlsCipherSuites: tlsCipherSuites,
-		proxyCache:      proxyCache,
+	h := &ProxyHandler{
+		tempoCache: tempoCache,
+		authorizer: authorizer,
+		k8sclient:  k8sclient,
+		opts:       opts,
+		proxyCache: proxyCache,
+		allowlist:  newPathAllowlist(opts.allowedPaths()),
+		traceCache: newTraceCache(),
+
+		rateLimiter: newRateLimiter(opts.RateLimits),
+
+		transports:      map[string]*pooledTransport{},
+		tlsFingerprints: map[string]string{},
 	}
+	if tempoCache != nil {
+		tempoCache.AddChangeHandler(h.evictProxies)
+	}
+	return h
 }
 
 // These headers aren't things that proxies should pass along. Some are forbidden by http2.
@@ -67,108 +110,109 @@ func FilterHeaders(r *http.Response) error {
 	return nil
 }
 
+// buildTLSConfig returns the TLS config for Tempo instances managed by the Tempo operator.
 func (h *ProxyHandler) buildTLSConfig() (*tls.Config, error) {
-	tlsConfig := oscrypto.SecureTLSConfig(&tls.Config{})
-
-	if h.serviceCAfile != "" {
-		serviceCertPEM, err := os.ReadFile(h.serviceCAfile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read certificate file: tried '%s' and got %v", h.serviceCAfile, err)
-		}
-
-		serviceProxyRootCAs := x509.NewCertPool()
-		if !serviceProxyRootCAs.AppendCertsFromPEM(serviceCertPEM) {
-			return nil, fmt.Errorf("no CA found for Kubernetes services, proxy to datasources will fail")
-		}
-		tlsConfig.RootCAs = serviceProxyRootCAs
+	material, err := h.loadTLSMaterial(context.Background(), api.TLSConfig{CAFile: h.opts.ServiceCAFile})
+	if err != nil {
+		return nil, err
 	}
+	return h.buildTLSConfigFromMaterial("Tempo instances", material)
+}
 
-	if h.tlsMinVersion != 0 {
-		tlsConfig.MinVersion = h.tlsMinVersion
-	}
-	if len(h.tlsCipherSuites) > 0 {
-		tlsConfig.CipherSuites = h.tlsCipherSuites
+func (h *ProxyHandler) createProxy(ctx context.Context, tempo api.TempoResource, tenant string) (*httputil.ReverseProxy, error) {
+	if tempo.Kind == api.KindStaticDatasource {
+		return h.createStaticDatasourceProxy(ctx, tempo, tenant)
 	}
 
-	return tlsConfig, nil
-}
+	serviceProxyTLSConfig, fingerprint, err := h.tlsConfigFor(ctx, tempo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errTLSConfig, err)
+	}
 
-func (h *ProxyHandler) createProxy(tempo api.TempoResource, tenant string) (*httputil.ReverseProxy, error) {
-	// TODO: allow custom CA per datasource
-	serviceProxyTLSConfig, err := h.buildTLSConfig()
+	endpoint, err := resolveEndpoint(ctx, h.k8sclient, tempo)
 	if err != nil {
 		return nil, err
 	}
+	log.WithFields(logrus.Fields{"namespace": tempo.Namespace, "tempo": tempo.Name, "tenant": tenant, "source": endpoint.source}).
+		Infof("proxying requests to %s", endpoint)
 
-	const (
-		dialerKeepalive       = 30 * time.Second
-		dialerTimeout         = 5 * time.Minute // Maximum request timeout for most browsers.
-		tlsHandshakeTimeout   = 10 * time.Second
-		websocketPingInterval = 30 * time.Second
-		websocketTimeout      = 30 * time.Second
-	)
-
-	dialer := &net.Dialer{
-		Timeout:   dialerTimeout,
-		KeepAlive: dialerKeepalive,
+	targetURL := endpoint.String()
+	if !tempo.SingleTenant {
+		targetURL += fmt.Sprintf("/api/traces/v1/%s/tempo", url.PathEscape(tenant))
 	}
 
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dialer.DialContext(ctx, network, addr)
-		},
-		TLSClientConfig:     serviceProxyTLSConfig,
-		TLSHandshakeTimeout: tlsHandshakeTimeout,
-	}
+	// For local development, set the target URL to a local Tempo instance
+	// targetURL = "http://localhost:3200"
 
-	var targetURL string
-	switch tempo.Kind {
-	case api.KindTempoStack:
-		if len(tempo.Tenants) > 0 {
-			service := DNSName(fmt.Sprintf("tempo-%s-gateway", tempo.Name))
-			targetURL = fmt.Sprintf("https://%s.%s.svc:8080/api/traces/v1/%s/tempo", service, tempo.Namespace, url.PathEscape(tenant))
-		} else {
-			service := DNSName(fmt.Sprintf("tempo-%s-query-frontend", tempo.Name))
-			targetURL = fmt.Sprintf("http://%s.%s.svc:3200", service, tempo.Namespace)
-		}
+	proxyURL, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
 
-	case api.KindTempoMonolithic:
-		if len(tempo.Tenants) > 0 {
-			service := DNSName(fmt.Sprintf("tempo-%s-gateway", tempo.Name))
-			targetURL = fmt.Sprintf("https://%s.%s.svc:8080/api/traces/v1/%s/tempo", service, tempo.Namespace, url.PathEscape(tenant))
-		} else {
-			service := DNSName(fmt.Sprintf("tempo-%s", tempo.Name))
-			targetURL = fmt.Sprintf("http://%s.%s.svc:3200", service, tempo.Namespace)
+	reverseProxy := newReverseProxy(proxyURL, h.transportFor(instanceKey(tempo.Namespace, tempo.Name), proxyURL, fingerprint, serviceProxyTLSConfig))
+	if instance, ok := h.opts.instanceConfig(tempo.Namespace, tempo.Name); ok && instance.ServiceAccountFallback && !tempo.SingleTenant {
+		reverseProxy.Transport = &serviceAccountFallbackTransport{base: reverseProxy.Transport, tokenFile: h.opts.serviceAccountTokenFile()}
+	}
+	if tempo.SingleTenant {
+		// Single-tenant instances are queried via plain HTTP and do not authenticate requests,
+		// therefore the credentials of the console user must not be forwarded.
+		director := reverseProxy.Director
+		reverseProxy.Director = func(r *http.Request) {
+			director(r)
+			r.Header.Del("Authorization")
+			r.Header.Del("Cookie")
 		}
+	}
+	return reverseProxy, nil
+}
 
-	default:
-		return nil, fmt.Errorf("invalid Tempo resource with kind '%s'", tempo.Kind)
+// createStaticDatasourceProxy creates a proxy for a datasource of the plugin configuration.
+// The Authorization header is set by applyDatasourceAuthorization.
+func (h *ProxyHandler) createStaticDatasourceProxy(ctx context.Context, tempo api.TempoResource, tenant string) (*httputil.ReverseProxy, error) {
+	ds, ok := h.tempoCache.GetStaticDatasource(tempo.Name)
+	if !ok {
+		return nil, fmt.Errorf("%w: datasource '%s' not found", errTempoNotFound, tempo.Name)
 	}
 
-	// For local development, set the target URL to a local Tempo instance
-	// targetURL = "http://localhost:3200"
+	tlsConfig, fingerprint, err := h.tlsConfigFor(ctx, tempo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errTLSConfig, err)
+	}
 
-	proxyURL, err := url.Parse(targetURL)
+	proxyURL, err := url.Parse(ds.URL)
 	if err != nil {
 		return nil, err
 	}
+	log.WithFields(logrus.Fields{"datasource": ds.Name, "tenant": tenant}).Infof("proxying requests to %s", proxyURL.Redacted())
+
+	reverseProxy := newReverseProxy(proxyURL, h.transportFor(instanceKey(tempo.Namespace, tempo.Name), proxyURL, fingerprint, tlsConfig))
+	director := reverseProxy.Director
+	reverseProxy.Director = func(r *http.Request) {
+		director(r)
+		// the session cookie of the console is never forwarded
+		r.Header.Del("Cookie")
+		if !tempo.SingleTenant {
+			r.Header.Set("X-Scope-OrgID", tenant)
+		}
+	}
+	return reverseProxy, nil
+}
 
+// newReverseProxy creates a proxy to a Tempo instance. The transport is shared by all proxies to the same host.
+func newReverseProxy(proxyURL *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
 	reverseProxy := httputil.NewSingleHostReverseProxy(proxyURL)
 	reverseProxy.FlushInterval = time.Millisecond * 100
 	reverseProxy.Transport = transport
-	reverseProxy.ModifyResponse = FilterHeaders
+	reverseProxy.ModifyResponse = func(r *http.Response) error {
+		if err := FilterHeaders(r); err != nil {
+			return err
+		}
+		return translateGatewayAuthError(r)
+	}
 	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("http: proxy error: %v", err)
-		w.WriteHeader(http.StatusBadGateway)
-		fmt.Fprintf(w, "Error connecting to Tempo instance: %s", err)
+		writeError(w, fmt.Errorf("error connecting to Tempo instance: %w", err))
 	}
-	return reverseProxy, nil
-}
-
-func handleError(w http.ResponseWriter, code int, err error) {
-	log.Error(err)
-	http.Error(w, err.Error(), code)
+	return reverseProxy
 }
 
 func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -179,59 +223,332 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	tenant := vars["tenant"]
 
 	if len(namespace) == 0 {
-		handleError(w, http.StatusBadRequest, errors.New("cannot proxy request, namespace was not provided"))
+		api.WriteErrorResponse(w, http.StatusBadRequest, api.ErrorTypeBadRequest, errors.New("cannot proxy request, namespace was not provided"))
 		return
 	}
 
 	if len(name) == 0 {
-		handleError(w, http.StatusBadRequest, errors.New("cannot proxy request, tempo name was not provided"))
+		api.WriteErrorResponse(w, http.StatusBadRequest, api.ErrorTypeBadRequest, errors.New("cannot proxy request, tempo name was not provided"))
+		return
+	}
+
+	// the console forwards the bearer token of the user
+	user, err := h.authorizer.AuthenticateRequest(r.Context(), r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// AuthenticateRequest verified that the request contains a bearer token
+	token, _ := auth.BearerToken(r)
+
+	// the path of the forwarded request is relative to the Tempo API
+	prefix := fmt.Sprintf("/proxy/%s/%s/%s", url.PathEscape(namespace), url.PathEscape(name), url.PathEscape(tenant))
+	proxyRequest, ok := stripPrefix(r, prefix)
+	if !ok {
+		api.WriteErrorResponse(w, http.StatusNotFound, api.ErrorTypeNotFound, fmt.Errorf("cannot proxy request: path %s does not start with %s", r.URL.EscapedPath(), prefix))
 		return
 	}
 
+	// only the read-only query APIs of Tempo are forwarded
+	apiPath := proxyRequest.URL.Path
+	if !h.allowlist.allows(r.Method, apiPath) {
+		api.WriteErrorResponse(w, http.StatusForbidden, api.ErrorTypePathNotAllowed, fmt.Errorf("cannot proxy request: %s %s is not allowed", r.Method, apiPath))
+		return
+	}
+
+	generation := h.currentProxyCacheGeneration()
+
+	// validate if a Tempo resource exists with this namespace/name
+	tempo, err := h.lookupTempoResource(r.Context(), namespace, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Only tenants of the instance are accepted, before any access review, cache entry or URL is created for the tenant.
+	// The frontend sends a placeholder for single-tenant instances.
+	if !tempo.HasTenant(tenant) {
+		err = fmt.Errorf("%w: '%s' is not a tenant of %s/%s", errTenantNotFound, tenant, namespace, name)
+		if tempo.SingleTenant {
+			err = fmt.Errorf("%w: %s/%s is a single-tenant instance, expected tenant '%s'", errTenantNotFound, namespace, name, api.SingleTenantPlaceholder)
+		}
+		writeError(w, err)
+		return
+	}
+
+	// check the permissions of the user before the request leaves the plugin
+	err = h.authorize(r.Context(), user, tempo, tenant)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The gateway authenticates the console user with the bearer token. The header is normalized
+	// instead of relying on the format of the header passed by the console.
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	if tempo.TenancyMode == api.TenancyModeStatic {
+		// The gateway authenticates tenants with OIDC instead of OpenShift OAuth,
+		// therefore the configured token is sent instead of the token of the console user.
+		token, err := h.staticModeToken(tempo, tenant)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// responses of requests forwarded with the token of the user are only shared with the same user
+	scope := userScope(user.Name)
+	if tempo.TenancyMode == api.TenancyModeStatic || tempo.SingleTenant {
+		scope = "shared"
+	}
+
+	if tempo.Kind == api.KindStaticDatasource {
+		scope, err = h.applyDatasourceAuthorization(r, tempo, userScope(user.Name))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	// trace-by-ID lookups are served from the cache, after the user was authorized
+	traceKey, traceTTL, isTraceLookup := traceCacheKey(r, apiPath, instanceKey(namespace, name), tenant, scope)
+	switch {
+	case !isTraceLookup:
+	case traceKey == "":
+		metrics.TraceCacheRequestsTotal.WithLabelValues("bypass").Inc()
+		w.Header().Set("Cache-Status", cacheStatus("fwd=bypass"))
+	case requestBypassesCache(r):
+		metrics.TraceCacheRequestsTotal.WithLabelValues("bypass").Inc()
+		w.Header().Set("Cache-Status", cacheStatus("fwd=request"))
+	default:
+		if entry, ok := h.traceCache.get(traceKey); ok {
+			metrics.TraceCacheRequestsTotal.WithLabelValues("hit").Inc()
+			serveCachedTrace(w, entry)
+			return
+		}
+		metrics.TraceCacheRequestsTotal.WithLabelValues("miss").Inc()
+		w.Header().Set("Cache-Status", cacheStatus("fwd=miss"))
+	}
+
 	// Slashes are not allowed in the namespace or name fields, therefore it's a suitable cache key separator
 	cacheKey := fmt.Sprintf("%s/%s/%s", namespace, name, tenant)
 
-	// If two requests arrive simultaneously, it's likely that two proxy instances will be created,
-	// the later one overriding the first one in the cache.
-	// This could be avoided by locking, at the cost of performance.
+	// requests served from the trace cache are not limited, because they do not reach Tempo
+	release, retryAfter, limitScope, reason := h.rateLimiter.acquire(user.Name, cacheKey)
+	if release == nil {
+		metrics.ProxyRateLimitedTotal.WithLabelValues(limitScope, reason).Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		api.WriteErrorResponse(w, http.StatusTooManyRequests, api.ErrorTypeTooManyRequests,
+			fmt.Errorf("cannot proxy request: too many requests of the %s, retry after %s", limitScope, retryAfter.Round(time.Second)))
+		return
+	}
+	defer release()
+
 	proxy, ok := h.proxyCache.Get(cacheKey)
-	if !ok {
-		// proxy not found in cache, validate if a Tempo resource exists with this namespace/name
-		tempo, err := h.lookupTempoResource(r.Context(), namespace, name)
+	if ok {
+		metrics.ProxyCacheHitsTotal.Inc()
+	} else {
+		metrics.ProxyCacheMissesTotal.Inc()
+		proxy, err = h.getOrCreateProxy(r.Context(), cacheKey, tempo, tenant, generation)
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, fmt.Errorf("cannot proxy request: %w", err))
+			writeError(w, err)
 			return
 		}
+	}
+
+	start := time.Now()
+	recorder := metrics.NewStatusRecorder(w)
+	if traceKey != "" {
+		recorder.RecordBody(traceCacheMaxEntrySize)
+	}
+	proxy.ServeHTTP(recorder, proxyRequest)
+	if traceKey != "" {
+		if entry, ok := newCachedTrace(recorder, traceTTL); ok {
+			h.traceCache.add(traceKey, entry)
+		}
+	}
+	metrics.ProxyRequestDuration.WithLabelValues(namespace, name, tenant).Observe(time.Since(start).Seconds())
+	metrics.ProxyRequestsTotal.WithLabelValues(namespace, name, tenant, strconv.Itoa(recorder.Status())).Inc()
+}
+
+// stripPrefix returns a shallow copy of a request without the prefix of its path, like http.StripPrefix.
+// It returns false if the path does not start with the prefix.
+func stripPrefix(r *http.Request, prefix string) (*http.Request, bool) {
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	rawPath := strings.TrimPrefix(r.URL.RawPath, prefix)
+	if len(path) == len(r.URL.Path) || (r.URL.RawPath != "" && len(rawPath) == len(r.URL.RawPath)) {
+		return nil, false
+	}
+
+	stripped := new(http.Request)
+	*stripped = *r
+	stripped.URL = new(url.URL)
+	*stripped.URL = *r.URL
+	stripped.URL.Path = path
+	stripped.URL.RawPath = rawPath
+	return stripped, true
+}
+
+// authorize checks if a user is allowed to query a tenant of a Tempo instance.
+// It returns an error wrapping errForbidden if the user is not allowed.
+func (h *ProxyHandler) authorize(ctx context.Context, user *auth.User, tempo api.TempoResource, tenant string) error {
+	if tempo.Kind == api.KindStaticDatasource && tempo.SingleTenant {
+		// single-tenant static datasources do not have a namespace or tenants, they are accessible to all users
+		return nil
+	}
+
+	attributes := auth.ReadTracesAttributes(tenant)
+	if tempo.SingleTenant {
+		// single-tenant instances do not have tenants, require read access to the namespace of the instance instead
+		attributes = auth.ReadNamespaceAttributes(tempo.Namespace)
+	}
+
+	allowed, reason, err := h.authorizer.Authorize(ctx, user, attributes)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		if tempo.SingleTenant {
+			err = fmt.Errorf("%w: user %q cannot access namespace %q", errForbidden, user.Name, tempo.Namespace)
+		} else {
+			err = fmt.Errorf("%w: user %q cannot read traces of tenant %q", errForbidden, user.Name, tenant)
+		}
+		if reason != "" {
+			err = fmt.Errorf("%w: %s", err, reason)
+		}
+		return err
+	}
+	return nil
+}
+
+// applyDatasourceAuthorization sets the Authorization header of a request to a static datasource.
+// It returns the cache scope of the responses, which is the scope of the user if the token of the user is forwarded.
+func (h *ProxyHandler) applyDatasourceAuthorization(r *http.Request, tempo api.TempoResource, userScope string) (string, error) {
+	ds, ok := h.tempoCache.GetStaticDatasource(tempo.Name)
+	if !ok {
+		return "", fmt.Errorf("%w: datasource '%s' not found", errTempoNotFound, tempo.Name)
+	}
+
+	switch ds.Authorization.Type {
+	case api.AuthorizationUserToken:
+		// the bearer token of the user was already set by ServeHTTP
+		return userScope, nil
+
+	case api.AuthorizationTokenFile:
+		token, err := readTokenFile(ds.Authorization.TokenFile)
+		if err != nil {
+			return "", err
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		return "shared", nil
+
+	default:
+		r.Header.Del("Authorization")
+		return "shared", nil
+	}
+}
 
-		proxy, err = h.createProxy(tempo, tenant)
+// staticModeToken returns the configured token for a tenant of a Tempo instance in static tenancy mode.
+func (h *ProxyHandler) staticModeToken(tempo api.TempoResource, tenant string) (string, error) {
+	instance, ok := h.opts.instanceConfig(tempo.Namespace, tempo.Name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s/%s uses static tenancy mode, but no token is configured for this instance", errTenantNotConfigured, tempo.Namespace, tempo.Name)
+	}
+
+	tokenFile, err := instance.tokenFile(tenant)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errTenantNotConfigured, err)
+	}
+
+	return readTokenFile(tokenFile)
+}
+
+// readTokenFile returns the token of a file. The file is read on every request to pick up rotated tokens.
+func readTokenFile(path string) (string, error) {
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read token file: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+func (h *ProxyHandler) currentProxyCacheGeneration() uint64 {
+	h.proxyCacheMu.Lock()
+	defer h.proxyCacheMu.Unlock()
+	return h.proxyCacheGeneration
+}
+
+// getOrCreateProxy creates and caches a proxy. Concurrent requests with the same cache key wait for
+// the proxy created by the first request, instead of creating a proxy each.
+func (h *ProxyHandler) getOrCreateProxy(ctx context.Context, cacheKey string, tempo api.TempoResource, tenant string, generation uint64) (*httputil.ReverseProxy, error) {
+	// requests which started after proxies were evicted do not wait for a proxy of the previous state
+	flightKey := fmt.Sprintf("%s#%d", cacheKey, generation)
+	leader := false
+	proxy, err, shared := h.proxyGroup.Do(flightKey, func() (any, error) {
+		leader = true
+
+		// the proxy may have been cached after the cache lookup of this request
+		if proxy, ok := h.proxyCache.Peek(cacheKey); ok {
+			return proxy, nil
+		}
+
+		// the proxy is shared with the other waiting requests, therefore it is not bound to this request
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), createProxyTimeout)
+		defer cancel()
+		proxy, err := h.createProxy(ctx, tempo, tenant)
 		if err != nil {
-			handleError(w, http.StatusInternalServerError, fmt.Errorf("cannot proxy request: %w", err))
-			return
+			return nil, err
 		}
+		h.addProxy(cacheKey, proxy, generation)
+		return proxy, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared && !leader {
+		metrics.ProxyCacheCoalescedTotal.Inc()
+	}
+	return proxy.(*httputil.ReverseProxy), nil
+}
+
+// addProxy caches a proxy, unless proxies were evicted since the proxy was created.
+func (h *ProxyHandler) addProxy(cacheKey string, proxy *httputil.ReverseProxy, generation uint64) {
+	h.proxyCacheMu.Lock()
+	defer h.proxyCacheMu.Unlock()
+	if generation == h.proxyCacheGeneration {
 		h.proxyCache.Add(cacheKey, proxy)
 	}
+}
 
-	http.StripPrefix(fmt.Sprintf("/proxy/%s/%s/%s", url.PathEscape(namespace), url.PathEscape(name), url.PathEscape(tenant)), proxy).ServeHTTP(w, r)
+// evictProxies removes all cached proxies of a Tempo instance.
+// It is called whenever the Tempo resource is updated or deleted, and when its CA bundle or client certificate changes.
+func (h *ProxyHandler) evictProxies(namespace string, name string) {
+	h.proxyCacheMu.Lock()
+	defer h.proxyCacheMu.Unlock()
+	h.proxyCacheGeneration++
+
+	h.traceCache.removeInstance(namespace, name)
+	h.releaseTransports(namespace, name)
+
+	prefix := instanceKey(namespace, name) + "/"
+	for _, key := range h.proxyCache.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			log.WithFields(logrus.Fields{"namespace": namespace, "tempo": name}).Debugf("evicting proxy %s", key)
+			h.proxyCache.Remove(key)
+		}
+	}
 }
 
 func (h *ProxyHandler) lookupTempoResource(ctx context.Context, namespace string, name string) (api.TempoResource, error) {
-	resources, err := api.ListTempoResources(ctx, h.k8sclient)
+	tempo, found, err := h.tempoCache.GetTempoResource(ctx, namespace, name)
 	if err != nil {
 		return api.TempoResource{}, err
 	}
 
-	var tempo api.TempoResource
-	found := false
-	for _, resource := range resources {
-		if resource.Namespace == namespace && resource.Name == name {
-			found = true
-			tempo = resource
-			break
-		}
-	}
-
 	if !found {
-		return api.TempoResource{}, fmt.Errorf("%s/%s is not a valid Tempo resource", namespace, name)
+		return api.TempoResource{}, fmt.Errorf("%w: %s/%s is not a valid Tempo resource", errTempoNotFound, namespace, name)
 	}
 	return tempo, nil
 }
@@ -1,16 +1,91 @@
 package proxy
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/auth"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
+var tempostackGVR = schema.GroupVersionResource{Group: "tempo.grafana.com", Version: "v1alpha1", Resource: "tempostacks"}
+
+func newTempoStack(namespace string, name string, tenants ...string) *unstructured.Unstructured {
+	authentication := []interface{}{}
+	for _, tenant := range tenants {
+		authentication = append(authentication, map[string]interface{}{"tenantName": tenant, "tenantId": tenant})
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "tempo.grafana.com/v1alpha1",
+		"kind":       "TempoStack",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name, "resourceVersion": "1"},
+		"spec": map[string]interface{}{
+			"tenants": map[string]interface{}{
+				"mode":           "openshift",
+				"authentication": authentication,
+			},
+		},
+	}}
+}
+
+// newTempoCache starts a Tempo resource cache backed by a fake Kubernetes API.
+func newTempoCache(t *testing.T, objects ...runtime.Object) (*api.TempoCache, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	return newTempoCacheWithOptions(t, api.TempoCacheOptions{}, objects...)
+}
+
+func newTempoCacheWithOptions(t *testing.T, opts api.TempoCacheOptions, objects ...runtime.Object) (*api.TempoCache, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+
+	k8sclient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		tempostackGVR: "TempoStackList",
+		{Group: "tempo.grafana.com", Version: "v1alpha1", Resource: "tempomonolithics"}: "TempoMonolithicList",
+	}, objects...)
+	tempoCache := api.NewTempoCache(k8sclient, opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	tempoCache.Start(ctx)
+
+	_, err := tempoCache.ListTempoResources(ctx)
+	require.NoError(t, err)
+	return tempoCache, k8sclient
+}
+
 // startTLSServer starts a test HTTPS server with the given TLS config.
 // Returns the server and the path to a CA file containing the server's certificate.
 func startTLSServer(t *testing.T, tlsConfig *tls.Config) (*httptest.Server, string) {
@@ -62,7 +137,7 @@ func TestProxyTLS13MinVersion(t *testing.T) {
 	defer server.Close()
 
 	// Proxy with TLS 1.3 min version should succeed
-	handler13 := NewProxyHandler(nil, caFile, tls.VersionTLS13, nil)
+	handler13 := NewProxyHandler(nil, nil, nil, Options{ServiceCAFile: caFile, TLSMinVersion: tls.VersionTLS13})
 	client13 := buildClientFromHandler(t, handler13)
 
 	resp, err := client13.Get(server.URL + "/health")
@@ -70,7 +145,7 @@ func TestProxyTLS13MinVersion(t *testing.T) {
 	resp.Body.Close()
 
 	// Proxy with TLS 1.2 max version should be rejected by the TLS 1.3 server
-	handler12 := NewProxyHandler(nil, caFile, 0, nil)
+	handler12 := NewProxyHandler(nil, nil, nil, Options{ServiceCAFile: caFile})
 	tlsConfig, err := handler12.buildTLSConfig()
 	require.NoError(t, err)
 	tlsConfig.MaxVersion = tls.VersionTLS12
@@ -96,7 +171,7 @@ func TestProxyCipherSuites(t *testing.T) {
 	defer server.Close()
 
 	// Proxy with matching cipher suite should succeed
-	handlerMatch := NewProxyHandler(nil, caFile, 0, []uint16{serverCipherSuite})
+	handlerMatch := NewProxyHandler(nil, nil, nil, Options{ServiceCAFile: caFile, TLSCipherSuites: []uint16{serverCipherSuite}})
 	tlsConfig, err := handlerMatch.buildTLSConfig()
 	require.NoError(t, err)
 	tlsConfig.MaxVersion = tls.VersionTLS12
@@ -112,7 +187,7 @@ func TestProxyCipherSuites(t *testing.T) {
 	resp.Body.Close()
 
 	// Proxy with non-matching cipher suite should be rejected
-	handlerMismatch := NewProxyHandler(nil, caFile, 0, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384})
+	handlerMismatch := NewProxyHandler(nil, nil, nil, Options{ServiceCAFile: caFile, TLSCipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}})
 	tlsConfigMismatch, err := handlerMismatch.buildTLSConfig()
 	require.NoError(t, err)
 	tlsConfigMismatch.MaxVersion = tls.VersionTLS12
@@ -130,7 +205,7 @@ func TestProxyCipherSuites(t *testing.T) {
 func TestProxyTLSConfigNoCert(t *testing.T) {
 	// When no CA file is provided, buildTLSConfig should still return a valid TLS config
 	// with the min version and cipher suites applied
-	handler := NewProxyHandler(nil, "", tls.VersionTLS13, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+	handler := NewProxyHandler(nil, nil, nil, Options{TLSMinVersion: tls.VersionTLS13, TLSCipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}})
 	tlsConfig, err := handler.buildTLSConfig()
 	require.NoError(t, err)
 	require.NotNil(t, tlsConfig, "TLS config should not be nil even when no CA file is provided")
@@ -138,3 +213,825 @@ func TestProxyTLSConfigNoCert(t *testing.T) {
 	require.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion, "TLS min version should be set")
 	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites, "TLS cipher suites should be set")
 }
+
+func TestTLSConfigErrors(t *testing.T) {
+	invalidCAFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(invalidCAFile, []byte("not a certificate"), 0o600))
+	k8sclient := fake.NewClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "tempo-ca"},
+		Data:       map[string]string{"ca.crt": "not a certificate"},
+	})
+	tempoCache, _ := newTempoCacheWithOptions(t, api.TempoCacheOptions{
+		StaticDatasources: []api.StaticDatasource{
+			{Name: "file", URL: "https://tempo.example.com", TLS: api.TLSConfig{CAFile: invalidCAFile}},
+			{Name: "configmap", URL: "https://tempo.example.com", TLS: api.TLSConfig{CA: &api.ObjectKeyReference{Kind: "ConfigMap", Namespace: "ns", Name: "tempo-ca"}}},
+		},
+	})
+	handler := NewProxyHandler(tempoCache, nil, k8sclient, Options{
+		Instances: []InstanceConfig{{Namespace: "ns", Name: "stack", TLS: &api.TLSConfig{CAFile: invalidCAFile}}},
+	})
+
+	// the errors name the Tempo resource and the source of the CA bundle
+	_, _, err := handler.tlsConfigFor(context.Background(), api.TempoResource{Kind: api.KindStaticDatasource, Name: "file"})
+	require.EqualError(t, err, fmt.Sprintf("datasource file: CA file %s contains no certificates", invalidCAFile))
+	_, _, err = handler.tlsConfigFor(context.Background(), api.TempoResource{Kind: api.KindStaticDatasource, Name: "configmap"})
+	require.EqualError(t, err, "datasource configmap: key 'ca.crt' of ConfigMap ns/tempo-ca contains no certificates")
+	_, _, err = handler.tlsConfigFor(context.Background(), api.TempoResource{Kind: api.KindTempoStack, Namespace: "ns", Name: "stack"})
+	require.EqualError(t, err, fmt.Sprintf("Tempo instance ns/stack: CA file %s contains no certificates", invalidCAFile))
+}
+
+// newFakeAuthorizer returns an authorizer which authenticates the token "valid-token" as user "developer"
+// and allows reading the traces of the given tenants.
+func newFakeAuthorizer(allowedTenants ...string) *auth.Authorizer {
+	k8sclient := fake.NewClientset()
+	k8sclient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "valid-token" {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: "developer"}
+		}
+		return true, review, nil
+	})
+	k8sclient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		for _, tenant := range allowedTenants {
+			if review.Spec.ResourceAttributes.Resource == tenant && review.Spec.ResourceAttributes.Name == "traces" {
+				review.Status.Allowed = true
+			}
+		}
+		return true, review, nil
+	})
+	return auth.NewAuthorizer(k8sclient)
+}
+
+func serveProxyRequest(handler *ProxyHandler, path string, token string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.PathPrefix("/proxy/{namespace}/{name}/{tenant}").Handler(handler)
+
+	req := httptest.NewRequest("GET", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestProxyAuthorization(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("traces"))
+	}))
+	defer upstream.Close()
+
+	tempoCache, _ := newTempoCache(t, newTempoStack("ns", "stack", "dev", "prod"))
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{})
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	handler.proxyCache.Add("ns/stack/dev", httputil.NewSingleHostReverseProxy(upstreamURL))
+	handler.proxyCache.Add("ns/stack/prod", httputil.NewSingleHostReverseProxy(upstreamURL))
+
+	w := serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), `"errorType":"Unauthorized"`)
+
+	w = serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "invalid-token")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serveProxyRequest(handler, "/proxy/ns/stack/prod/api/search", "valid-token")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), `"errorType":"Forbidden"`)
+
+	w = serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "traces", w.Body.String())
+}
+
+func TestProxyTenantValidation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("traces"))
+	}))
+	defer upstream.Close()
+
+	tempoCache, _ := newTempoCacheWithOptions(t, api.TempoCacheOptions{
+		StaticDatasources: []api.StaticDatasource{
+			{Name: "shared", URL: upstream.URL},
+			{Name: "tenants", URL: upstream.URL, Tenants: []string{"dev"}},
+		},
+	})
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev", "prod"), nil, Options{})
+
+	w := serveProxyRequest(handler, "/proxy/_static/tenants/prod/api/search", "valid-token")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), `"errorType":"TenantNotFound"`)
+	require.Contains(t, w.Body.String(), "'prod' is not a tenant of _static/tenants")
+
+	// the placeholder is only valid for single-tenant instances
+	w = serveProxyRequest(handler, "/proxy/_static/tenants/single-tenant/api/search", "valid-token")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	w = serveProxyRequest(handler, "/proxy/_static/shared/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), "expected tenant 'single-tenant'")
+	require.Zero(t, handler.proxyCache.Len(), "no proxies are created for invalid tenants")
+
+	w = serveProxyRequest(handler, "/proxy/_static/shared/single-tenant/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveProxyRequest(handler, "/proxy/_static/tenants/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.ElementsMatch(t, []string{"_static/shared/single-tenant", "_static/tenants/dev"}, handler.proxyCache.Keys())
+}
+
+func TestProxyStaticTenancyMode(t *testing.T) {
+	var upstreamAuthorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuthorization = r.Header.Get("Authorization")
+		w.Write([]byte("traces"))
+	}))
+	defer upstream.Close()
+
+	tmpDir := t.TempDir()
+	tokenFile := tmpDir + "/token"
+	require.NoError(t, os.WriteFile(tokenFile, []byte("oidc-token\n"), 0600))
+	prodTokenFile := tmpDir + "/prod-token"
+	require.NoError(t, os.WriteFile(prodTokenFile, []byte("prod-oidc-token"), 0600))
+
+	staticStack := newTempoStack("ns", "stack", "dev", "prod")
+	require.NoError(t, unstructured.SetNestedField(staticStack.Object, "static", "spec", "tenants", "mode"))
+	tempoCache, _ := newTempoCache(t, staticStack)
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev", "prod"), nil, Options{
+		Instances: []InstanceConfig{{
+			Namespace:        "ns",
+			Name:             "stack",
+			TokenFile:        tokenFile,
+			TenantTokenFiles: map[string]string{"prod": prodTokenFile},
+		}},
+	})
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	handler.proxyCache.Add("ns/stack/dev", httputil.NewSingleHostReverseProxy(upstreamURL))
+	handler.proxyCache.Add("ns/stack/prod", httputil.NewSingleHostReverseProxy(upstreamURL))
+
+	w := serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "Bearer oidc-token", upstreamAuthorization)
+
+	w = serveProxyRequest(handler, "/proxy/ns/stack/prod/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "Bearer prod-oidc-token", upstreamAuthorization)
+
+	// the token of the console user is never forwarded to an instance in static tenancy mode
+	_, err = handler.staticModeToken(api.TempoResource{Namespace: "ns", Name: "unconfigured", TenancyMode: api.TenancyModeStatic}, "dev")
+	require.ErrorContains(t, err, "no token is configured")
+}
+
+func TestProxyStaticDatasource(t *testing.T) {
+	var upstreamRequest *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequest = r
+		w.Write([]byte("traces"))
+	}))
+	defer upstream.Close()
+
+	tokenFile := t.TempDir() + "/token"
+	require.NoError(t, os.WriteFile(tokenFile, []byte("datasource-token"), 0600))
+
+	tempoCache, _ := newTempoCacheWithOptions(t, api.TempoCacheOptions{
+		StaticDatasources: []api.StaticDatasource{
+			{Name: "shared", URL: upstream.URL + "/tempo"},
+			{
+				Name:          "multi",
+				URL:           upstream.URL,
+				Tenants:       []string{"dev", "prod"},
+				Authorization: api.DatasourceAuthorization{Type: api.AuthorizationTokenFile, TokenFile: tokenFile},
+			},
+		},
+	})
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{})
+
+	// single-tenant datasources are accessible to all users, and the user token is not forwarded
+	w := serveProxyRequest(handler, "/proxy/_static/shared/single-tenant/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "/tempo/api/search", upstreamRequest.URL.Path)
+	require.Empty(t, upstreamRequest.Header.Get("Authorization"))
+	require.Empty(t, upstreamRequest.Header.Get("X-Scope-OrgID"))
+
+	w = serveProxyRequest(handler, "/proxy/_static/multi/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "/api/search", upstreamRequest.URL.Path)
+	require.Equal(t, "Bearer datasource-token", upstreamRequest.Header.Get("Authorization"))
+	require.Equal(t, "dev", upstreamRequest.Header.Get("X-Scope-OrgID"))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.ProxyRequestsTotal.WithLabelValues("_static", "multi", "dev", "200")))
+
+	w = serveProxyRequest(handler, "/proxy/_static/multi/prod/api/search", "valid-token")
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestProxyGatewayAuthErrors(t *testing.T) {
+	var upstreamAuthorization []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuthorization = append(upstreamAuthorization, r.Header.Get("Authorization"))
+		switch r.Header.Get("Authorization") {
+		case "Bearer sa-token":
+			w.Write([]byte("traces"))
+		case "Bearer valid-token":
+			http.Error(w, "tenant dev not allowed", http.StatusForbidden)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("<html>Unauthorized</html>"))
+		}
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	tempoCache, _ := newTempoCache(t, newTempoStack("ns", "stack", "dev"))
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{})
+	handler.proxyCache.Add("ns/stack/dev", newReverseProxy(upstreamURL, newTransport(TransportConfig{}, nil)))
+
+	// the token of the user is forwarded, and the rejection of the gateway is returned as JSON
+	w := serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"status":"error","errorType":"GatewayForbidden","error":"the Tempo gateway rejected the request: tenant dev not allowed"}`, w.Body.String())
+	require.Equal(t, []string{"Bearer valid-token"}, upstreamAuthorization)
+
+	// with the service account fallback, rejected requests are retried with the token of the service account
+	saTokenFile := t.TempDir() + "/token"
+	require.NoError(t, os.WriteFile(saTokenFile, []byte("sa-token\n"), 0600))
+	reverseProxy := newReverseProxy(upstreamURL, newTransport(TransportConfig{}, nil))
+	reverseProxy.Transport = &serviceAccountFallbackTransport{base: reverseProxy.Transport, tokenFile: saTokenFile}
+	handler.proxyCache.Add("ns/stack/dev", reverseProxy)
+
+	upstreamAuthorization = nil
+	w = serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "traces", w.Body.String())
+	require.Equal(t, []string{"Bearer valid-token", "Bearer sa-token"}, upstreamAuthorization)
+
+	// HTML responses of the gateway are not included in the error message
+	require.NoError(t, os.WriteFile(saTokenFile, []byte("expired-token"), 0600))
+	w = serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.JSONEq(t, `{"status":"error","errorType":"GatewayUnauthorized","error":"the Tempo gateway rejected the request: Unauthorized"}`, w.Body.String())
+}
+
+func TestProxyAllowedPaths(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	tempoCache, _ := newTempoCache(t, newTempoStack("ns", "stack", "dev"))
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{})
+	handler.proxyCache.Add("ns/stack/dev", newReverseProxy(upstreamURL, newTransport(TransportConfig{}, nil)))
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/api/search?q={}", http.StatusOK},
+		{"GET", "/api/traces/1234", http.StatusOK},
+		{"GET", "/api/v2/search/tag/service.name/values", http.StatusOK},
+		{"GET", "/api/v2/search/tags", http.StatusOK},
+		{"POST", "/api/search", http.StatusForbidden},
+		{"GET", "/api/traces", http.StatusForbidden},
+		{"GET", "/api/traces/1234/extra", http.StatusForbidden},
+		{"GET", "/api/overrides", http.StatusForbidden},
+		{"POST", "/flush", http.StatusForbidden},
+		{"GET", "/shutdown", http.StatusForbidden},
+		{"GET", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			router := mux.NewRouter()
+			router.PathPrefix("/proxy/{namespace}/{name}/{tenant}").Handler(handler)
+			req := httptest.NewRequest(tt.method, "/proxy/ns/stack/dev"+tt.path, nil)
+			req.Header.Set("Authorization", "Bearer valid-token")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.code, w.Code)
+			if tt.code == http.StatusForbidden {
+				require.Contains(t, w.Body.String(), `"errorType":"PathNotAllowed"`)
+			}
+		})
+	}
+
+	// relative segments are rejected, even if they match a parameter
+	require.False(t, handler.allowlist.allows("GET", "/api/traces/../flush"))
+	require.False(t, handler.allowlist.allows("GET", "/api/v2/search/tag/../values"))
+
+	// the allowlist can be replaced in the plugin configuration
+	handler = NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{
+		AllowedPaths: []AllowedPath{{Path: "/api/search", Methods: []string{"GET", "POST"}}},
+	})
+	handler.proxyCache.Add("ns/stack/dev", newReverseProxy(upstreamURL, newTransport(TransportConfig{}, nil)))
+	require.Equal(t, http.StatusOK, serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token").Code)
+	require.Equal(t, http.StatusForbidden, serveProxyRequest(handler, "/proxy/ns/stack/dev/api/traces/1234", "valid-token").Code)
+}
+
+func TestProxyTraceCache(t *testing.T) {
+	upstreamRequests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"batches":[]}`))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	tempoCache, _ := newTempoCache(t, newTempoStack("ns", "stack", "dev"))
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{})
+	handler.proxyCache.Add("ns/stack/dev", newReverseProxy(upstreamURL, newTransport(TransportConfig{}, nil)))
+	hits := testutil.ToFloat64(metrics.TraceCacheRequestsTotal.WithLabelValues("hit"))
+
+	w := serveProxyRequest(handler, "/proxy/ns/stack/dev/api/traces/ABC123", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "distributed-tracing-console-plugin; fwd=miss", w.Header().Get("Cache-Status"))
+
+	// the trace id is normalized, lookups without a time range are cached for a short time
+	w = serveProxyRequest(handler, "/proxy/ns/stack/dev/api/traces/00abc123", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"batches":[]}`, w.Body.String())
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.Regexp(t, `^distributed-tracing-console-plugin; hit; ttl=(29|30)$`, w.Header().Get("Cache-Status"))
+	require.Equal(t, 1, upstreamRequests)
+	require.Equal(t, hits+1, testutil.ToFloat64(metrics.TraceCacheRequestsTotal.WithLabelValues("hit")))
+
+	// lookups in a different time range or API version are cached separately
+	start := time.Now().Add(-time.Hour).Unix()
+	path := fmt.Sprintf("/proxy/ns/stack/dev/api/v2/traces/abc123?start=%d&end=%d", start, start+60)
+	serveProxyRequest(handler, path, "valid-token")
+	w = serveProxyRequest(handler, path, "valid-token")
+	require.Regexp(t, `^distributed-tracing-console-plugin; hit; ttl=(299|300)$`, w.Header().Get("Cache-Status"))
+	require.Equal(t, 2, upstreamRequests)
+
+	// traces which ended recently can still change
+	path = fmt.Sprintf("/proxy/ns/stack/dev/api/traces/abc123?end=%d", time.Now().Unix())
+	w = serveProxyRequest(handler, path, "valid-token")
+	require.Equal(t, "distributed-tracing-console-plugin; fwd=bypass", w.Header().Get("Cache-Status"))
+	serveProxyRequest(handler, path, "valid-token")
+	require.Equal(t, 4, upstreamRequests)
+
+	// other APIs are not cached
+	w = serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+	require.Empty(t, w.Header().Get("Cache-Status"))
+
+	// a changed Tempo instance removes its traces from the cache
+	handler.evictProxies("ns", "stack")
+	require.Zero(t, handler.traceCache.entries.Len())
+
+	// entries expire after their TTL
+	handler.traceCache.add("ns/stack/dev/expired", &cachedTrace{stored: time.Now().Add(-time.Minute), ttl: traceCacheShortTTL})
+	_, ok := handler.traceCache.get("ns/stack/dev/expired")
+	require.False(t, ok)
+	require.Zero(t, handler.traceCache.entries.Len())
+}
+
+func TestTraceCacheKey(t *testing.T) {
+	newRequest := func(path string) *http.Request {
+		return httptest.NewRequest("GET", path, nil)
+	}
+
+	key, ttl, ok := traceCacheKey(newRequest("/api/traces/abc"), "/api/traces/abc", "ns/stack", "dev", userScope("developer"))
+	require.True(t, ok)
+	require.Equal(t, traceCacheShortTTL, ttl, "lookups without an end are cached for a short time")
+	otherUser, _, _ := traceCacheKey(newRequest("/api/traces/abc"), "/api/traces/abc", "ns/stack", "dev", userScope("admin"))
+	require.NotEqual(t, key, otherUser)
+
+	path := fmt.Sprintf("/api/traces/abc?end=%d", time.Now().Add(-time.Hour).Unix())
+	key, ttl, _ = traceCacheKey(newRequest(path), "/api/traces/abc", "ns/stack", "dev", "shared")
+	require.NotEmpty(t, key)
+	require.Equal(t, traceCacheTTL, ttl)
+
+	_, _, ok = traceCacheKey(newRequest("/api/search"), "/api/search", "ns/stack", "dev", "shared")
+	require.False(t, ok)
+
+	key, _, ok = traceCacheKey(newRequest("/api/traces/xyz"), "/api/traces/xyz", "ns/stack", "dev", "shared")
+	require.True(t, ok)
+	require.Empty(t, key, "invalid trace ids are not cached")
+
+	key, _, _ = traceCacheKey(newRequest("/api/traces/abc?start=yesterday"), "/api/traces/abc", "ns/stack", "dev", "shared")
+	require.Empty(t, key)
+}
+
+func TestProxyCacheInvalidation(t *testing.T) {
+	tempoCache, k8sclient := newTempoCache(t, newTempoStack("ns", "stack", "dev", "prod"), newTempoStack("ns", "other", "dev"))
+	handler := NewProxyHandler(tempoCache, nil, nil, Options{})
+
+	handler.proxyCache.Add("ns/stack/dev", &httputil.ReverseProxy{})
+	handler.proxyCache.Add("ns/stack/prod", &httputil.ReverseProxy{})
+	handler.proxyCache.Add("ns/other/dev", &httputil.ReverseProxy{})
+
+	// updating the tenants of a Tempo instance evicts all proxies of this instance
+	updated := newTempoStack("ns", "stack", "dev")
+	updated.SetResourceVersion("2")
+	_, err := k8sclient.Resource(tempostackGVR).Namespace("ns").Update(context.Background(), updated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return !handler.proxyCache.Contains("ns/stack/dev") && !handler.proxyCache.Contains("ns/stack/prod")
+	}, 5*time.Second, 50*time.Millisecond)
+	require.True(t, handler.proxyCache.Contains("ns/other/dev"), "proxies of other instances must not be evicted")
+
+	// deleting a Tempo instance evicts all proxies of this instance
+	err = k8sclient.Resource(tempostackGVR).Namespace("ns").Delete(context.Background(), "other", metav1.DeleteOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return !handler.proxyCache.Contains("ns/other/dev")
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestProxyCacheGeneration(t *testing.T) {
+	handler := NewProxyHandler(nil, nil, nil, Options{})
+
+	// a proxy created before an eviction must not be cached
+	generation := handler.currentProxyCacheGeneration()
+	handler.evictProxies("ns", "stack")
+	handler.addProxy("ns/stack/dev", &httputil.ReverseProxy{}, generation)
+	require.False(t, handler.proxyCache.Contains("ns/stack/dev"))
+
+	generation = handler.currentProxyCacheGeneration()
+	handler.addProxy("ns/stack/dev", &httputil.ReverseProxy{}, generation)
+	require.True(t, handler.proxyCache.Contains("ns/stack/dev"))
+}
+
+func TestCreateProxySingleTenant(t *testing.T) {
+	handler := NewProxyHandler(nil, nil, nil, Options{})
+	proxy, err := handler.createProxy(context.Background(), api.TempoResource{Kind: api.KindTempoMonolithic, Namespace: "ns", Name: "mono", SingleTenant: true}, "single-tenant")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/api/search", nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+	proxy.Director(req)
+
+	require.Equal(t, "http://tempo-mono.ns.svc:3200/api/search", req.URL.String())
+	require.Empty(t, req.Header.Get("Authorization"), "user credentials must not be forwarded to single-tenant instances")
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	gatewayService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns",
+			Name:      "custom-gateway",
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "tempo",
+				"app.kubernetes.io/instance":   "stack",
+				"app.kubernetes.io/component":  "gateway",
+				"app.kubernetes.io/managed-by": "tempo-operator",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports: []corev1.ServicePort{
+				{Name: "internal", Port: 8081},
+				{Name: "public", Port: 8443},
+			},
+		},
+	}
+	headlessService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns",
+			Name:      "a-discovery",
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "tempo-monolithic",
+				"app.kubernetes.io/instance":   "mono",
+				"app.kubernetes.io/component":  "tempo",
+				"app.kubernetes.io/managed-by": "tempo-operator",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports:     []corev1.ServicePort{{Name: "http", Port: 3200}},
+		},
+	}
+	k8sclient := fake.NewClientset(gatewayService, headlessService)
+
+	// the service is selected by its labels, and the port by its name
+	e, err := resolveEndpoint(context.Background(), k8sclient, api.TempoResource{Kind: api.KindTempoStack, Namespace: "ns", Name: "stack", Tenants: []string{"dev"}})
+	require.NoError(t, err)
+	require.Equal(t, endpoint{scheme: "https", host: "custom-gateway.ns.svc:8443", source: endpointSourceService}, e)
+
+	// headless services are skipped
+	e, err = resolveEndpoint(context.Background(), k8sclient, api.TempoResource{Kind: api.KindTempoMonolithic, Namespace: "ns", Name: "mono", SingleTenant: true})
+	require.NoError(t, err)
+	require.Equal(t, endpoint{scheme: "http", host: "tempo-mono.ns.svc:3200", source: endpointSourceConvention}, e)
+
+	// fall back to the naming conventions if no service is found
+	e, err = resolveEndpoint(context.Background(), k8sclient, api.TempoResource{Kind: api.KindTempoStack, Namespace: "other", Name: "stack", Tenants: []string{"dev"}})
+	require.NoError(t, err)
+	require.Equal(t, endpoint{scheme: "https", host: "tempo-stack-gateway.other.svc:8080", source: endpointSourceConvention}, e)
+}
+
+func TestProxyConcurrentCacheMisses(t *testing.T) {
+	server, caFile := startTLSServer(t, &tls.Config{})
+	defer server.Close()
+	caPEM, err := os.ReadFile(caFile)
+	require.NoError(t, err)
+
+	// loading the CA bundle is slow, to let the requests arrive while the proxy is created
+	var caLoads atomic.Int32
+	k8sclient := fake.NewClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "tempo-ca"},
+		Data:       map[string]string{"service-ca.crt": string(caPEM)},
+	})
+	k8sclient.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		caLoads.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return false, nil, nil
+	})
+
+	tempoCache, _ := newTempoCacheWithOptions(t, api.TempoCacheOptions{
+		StaticDatasources: []api.StaticDatasource{{
+			Name:    "multi",
+			URL:     server.URL,
+			Tenants: []string{"dev", "prod"},
+			TLS: api.TLSConfig{
+				CA: &api.ObjectKeyReference{Kind: "ConfigMap", Namespace: "ns", Name: "tempo-ca", Key: "service-ca.crt"},
+			},
+		}},
+	})
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev", "prod"), k8sclient, Options{})
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serveProxyRequest(handler, "/proxy/_static/multi/dev/api/search", "valid-token").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		require.Equal(t, http.StatusOK, code)
+	}
+	require.Equal(t, int32(1), caLoads.Load(), "concurrent requests must create a single proxy")
+	require.Equal(t, 4.0, testutil.ToFloat64(metrics.ProxyCacheCoalescedTotal))
+
+	// the proxies of all tenants share the transport of the instance
+	w := serveProxyRequest(handler, "/proxy/_static/multi/prod/api/search", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	dev, _ := handler.proxyCache.Peek("_static/multi/dev")
+	prod, _ := handler.proxyCache.Peek("_static/multi/prod")
+	require.Same(t, dev.Transport, prod.Transport)
+	require.Len(t, handler.transports, 1)
+
+	handler.evictProxies("_static", "multi")
+	require.Empty(t, handler.transports)
+}
+
+func TestTransportPool(t *testing.T) {
+	handler := NewProxyHandler(nil, nil, nil, Options{Transport: TransportConfig{MaxConnsPerHost: 8, ResponseHeaderTimeout: time.Minute}})
+	tempo, _ := url.Parse("https://tempo.example.com:3200/tempo")
+	other, _ := url.Parse("https://other.example.com:3200")
+
+	// instances with the same host and TLS material share a transport
+	transport := handler.transportFor("_static/a", tempo, "ca1", nil)
+	require.Same(t, transport, handler.transportFor("_static/b", tempo, "ca1", nil))
+	require.NotSame(t, transport, handler.transportFor("_static/a", tempo, "ca2", nil))
+	require.NotSame(t, transport, handler.transportFor("_static/c", other, "ca1", nil))
+
+	require.Equal(t, 8, transport.MaxConnsPerHost)
+	require.Equal(t, defaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	require.Equal(t, time.Minute, transport.ResponseHeaderTimeout)
+	require.True(t, transport.ForceAttemptHTTP2)
+
+	// a transport is removed when no instance uses it anymore
+	handler.releaseTransports("_static", "a")
+	require.Len(t, handler.transports, 2)
+	require.Same(t, transport, handler.transportFor("_static/b", tempo, "ca1", nil))
+	handler.releaseTransports("_static", "b")
+	handler.releaseTransports("_static", "c")
+	require.Empty(t, handler.transports)
+
+	require.False(t, newTransport(TransportConfig{DisableHTTP2: true}, nil).ForceAttemptHTTP2)
+	require.EqualError(t, (&TransportConfig{DialTimeout: -time.Second}).Validate(), "transport: dialTimeout must not be negative, got -1s")
+}
+
+func TestProxyRateLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	tempoCache, _ := newTempoCache(t, newTempoStack("ns", "stack", "dev"))
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{
+		RateLimits: RateLimitConfig{User: LimitConfig{RequestsPerSecond: 0.1, Burst: 2}},
+	})
+	handler.proxyCache.Add("ns/stack/dev", newReverseProxy(upstreamURL, newTransport(TransportConfig{}, nil)))
+
+	for range 2 {
+		w := serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	w := serveProxyRequest(handler, "/proxy/ns/stack/dev/api/search", "valid-token")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Contains(t, w.Body.String(), `"errorType":"TooManyRequests"`)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	require.InDelta(t, 10, retryAfter, 1)
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.ProxyRateLimitedTotal.WithLabelValues("user", "rate")))
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{
+		User:   LimitConfig{MaxInFlight: 2},
+		Tenant: LimitConfig{RequestsPerSecond: 1, MaxInFlight: 1},
+	})
+
+	release, _, _, _ := limiter.acquire("developer", "ns/stack/dev")
+	require.NotNil(t, release)
+
+	// the tenant has a request in flight
+	_, retryAfter, scope, reason := limiter.acquire("admin", "ns/stack/dev")
+	require.Equal(t, "tenant", scope)
+	require.Equal(t, "concurrency", reason)
+	require.Equal(t, time.Second, retryAfter)
+
+	// the user has requests in flight to other tenants
+	release2, _, _, _ := limiter.acquire("developer", "ns/stack/prod")
+	require.NotNil(t, release2)
+	_, _, scope, reason = limiter.acquire("developer", "ns/stack/test")
+	require.Equal(t, "user", scope)
+	require.Equal(t, "concurrency", reason)
+
+	// the rate limit of the tenant applies after the request completed
+	release()
+	_, retryAfter, scope, reason = limiter.acquire("developer", "ns/stack/dev")
+	require.Equal(t, "tenant", scope)
+	require.Equal(t, "rate", reason)
+	require.Greater(t, retryAfter, time.Duration(0))
+	release2()
+
+	// inactive limiters are removed
+	limiter.users.cleanup(time.Now().Add(rateLimiterIdleTimeout + time.Minute))
+	require.Empty(t, limiter.users.entries)
+
+	config := RateLimitConfig{Tenant: LimitConfig{Burst: 10}}
+	require.EqualError(t, config.Validate(), "rateLimits: burst of tenant requires requestsPerSecond")
+}
+
+func TestProxyErrors(t *testing.T) {
+	tlsServer, _ := startTLSServer(t, &tls.Config{})
+	defer tlsServer.Close()
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slowServer.Close()
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedServer.Close()
+
+	tempoCache, _ := newTempoCache(t, newTempoStack("ns", "stack", "dev"), newTempoStack("ns", "tls", "dev"),
+		newTempoStack("ns", "slow", "dev"), newTempoStack("ns", "closed", "dev"))
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer("dev"), nil, Options{
+		Transport: TransportConfig{ResponseHeaderTimeout: 100 * time.Millisecond},
+	})
+	addProxy := func(cacheKey string, target string) {
+		targetURL, err := url.Parse(target)
+		require.NoError(t, err)
+		handler.proxyCache.Add(cacheKey, newReverseProxy(targetURL, newTransport(handler.opts.Transport, &tls.Config{})))
+	}
+	addProxy("ns/tls/dev", tlsServer.URL)
+	addProxy("ns/slow/dev", slowServer.URL)
+	addProxy("ns/closed/dev", closedServer.URL)
+
+	tests := []struct {
+		path      string
+		code      int
+		errorType string
+	}{
+		{"/proxy/ns/unknown/dev/api/search", http.StatusNotFound, api.ErrorTypeTempoNotFound},
+		// the router decodes the tenant, which then does not match the escaped path prefix
+		{"/proxy/ns/stack/d%20ev/api/search", http.StatusNotFound, api.ErrorTypeNotFound},
+		{"/proxy/ns/tls/dev/api/search", http.StatusBadGateway, api.ErrorTypeGatewayTLS},
+		{"/proxy/ns/slow/dev/api/search", http.StatusGatewayTimeout, api.ErrorTypeGatewayTimeout},
+		{"/proxy/ns/closed/dev/api/search", http.StatusBadGateway, api.ErrorTypeGatewayUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := serveProxyRequest(handler, tt.path, "valid-token")
+			require.Equal(t, tt.code, w.Code)
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			require.Contains(t, w.Body.String(), fmt.Sprintf(`"errorType":%q`, tt.errorType))
+		})
+	}
+
+	code, errorType := classifyError(fmt.Errorf("%w: configmap not found", errTLSConfig))
+	require.Equal(t, http.StatusInternalServerError, code)
+	require.Equal(t, api.ErrorTypeTLSConfig, errorType)
+	_, errorType = classifyError(context.Canceled)
+	require.Equal(t, api.ErrorTypeRequestCanceled, errorType)
+}
+
+func TestStripPrefix(t *testing.T) {
+	r := httptest.NewRequest("GET", "/proxy/ns/stack/dev/api/traces/1234?start=1", nil)
+	stripped, ok := stripPrefix(r, "/proxy/ns/stack/dev")
+	require.True(t, ok)
+	require.Equal(t, "/api/traces/1234", stripped.URL.Path)
+	require.Equal(t, "start=1", stripped.URL.RawQuery)
+	require.Equal(t, "/proxy/ns/stack/dev/api/traces/1234", r.URL.Path, "the original request is not modified")
+
+	_, ok = stripPrefix(r, "/proxy/ns/other/dev")
+	require.False(t, ok)
+
+	// the escaped path must match as well
+	r = httptest.NewRequest("GET", "/proxy/ns/stack/a%2Fb/api/search", nil)
+	_, ok = stripPrefix(r, "/proxy/ns/stack/a/b")
+	require.False(t, ok)
+}
+
+// generateClientCertificate returns a self-signed PEM encoded client certificate and key.
+func generateClientCertificate(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "distributed-tracing-console-plugin"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+}
+
+func TestProxyTLSFromKubernetesObjects(t *testing.T) {
+	clientCertPEM, clientKeyPEM := generateClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	require.True(t, clientCAs.AppendCertsFromPEM(clientCertPEM))
+
+	// the server requires a client certificate
+	server, caFile := startTLSServer(t, &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	})
+	defer server.Close()
+	caPEM, err := os.ReadFile(caFile)
+	require.NoError(t, err)
+
+	k8sclient := fake.NewClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "tempo-ca"},
+			Data:       map[string]string{"service-ca.crt": string(caPEM)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "tempo-client"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: clientCertPEM, corev1.TLSPrivateKeyKey: clientKeyPEM},
+		},
+	)
+	tempoCache, _ := newTempoCacheWithOptions(t, api.TempoCacheOptions{
+		StaticDatasources: []api.StaticDatasource{{
+			Name: "mtls",
+			URL:  server.URL,
+			TLS: api.TLSConfig{
+				CA:         &api.ObjectKeyReference{Kind: "ConfigMap", Namespace: "ns", Name: "tempo-ca", Key: "service-ca.crt"},
+				CertSecret: &api.ObjectReference{Namespace: "ns", Name: "tempo-client"},
+			},
+		}},
+	})
+	handler := NewProxyHandler(tempoCache, newFakeAuthorizer(), k8sclient, Options{})
+
+	w := serveProxyRequest(handler, "/proxy/_static/mtls/single-tenant/api/echo", "valid-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "ok", w.Body.String())
+	require.True(t, handler.proxyCache.Contains("_static/mtls/single-tenant"))
+
+	// unchanged TLS material keeps the proxies
+	handler.reloadTLS(context.Background())
+	require.True(t, handler.proxyCache.Contains("_static/mtls/single-tenant"))
+
+	// a rotated client certificate evicts the proxies
+	newCertPEM, newKeyPEM := generateClientCertificate(t)
+	_, err = k8sclient.CoreV1().Secrets("ns").Update(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "tempo-client"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: newCertPEM, corev1.TLSPrivateKeyKey: newKeyPEM},
+	}, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	handler.reloadTLS(context.Background())
+	require.False(t, handler.proxyCache.Contains("_static/mtls/single-tenant"))
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiters of users and tenants without requests for this time are removed
+	rateLimiterIdleTimeout = 10 * time.Minute
+	// retryAfterInFlight is the Retry-After of requests rejected because of too many requests in flight
+	retryAfterInFlight = time.Second
+)
+
+// RateLimitConfig contains the limits of proxied requests per user and per tenant of a Tempo instance.
+type RateLimitConfig struct {
+	// User limits the requests of each console user.
+	User LimitConfig `json:"user,omitempty" yaml:"user,omitempty"`
+	// Tenant limits the requests to each tenant of a Tempo instance, of all users.
+	Tenant LimitConfig `json:"tenant,omitempty" yaml:"tenant,omitempty"`
+}
+
+// LimitConfig is a token bucket rate limit and a limit of concurrent requests. Zero values disable a limit.
+type LimitConfig struct {
+	// RequestsPerSecond is the rate at which tokens are added to the bucket.
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty" yaml:"requestsPerSecond,omitempty"`
+	// Burst is the size of the bucket. Defaults to RequestsPerSecond, rounded up.
+	Burst int `json:"burst,omitempty" yaml:"burst,omitempty"`
+	// MaxInFlight limits the requests in flight.
+	MaxInFlight int `json:"maxInFlight,omitempty" yaml:"maxInFlight,omitempty"`
+}
+
+// Validate checks that no limit is negative.
+func (c *RateLimitConfig) Validate() error {
+	limits := []struct {
+		scope string
+		limit LimitConfig
+	}{
+		{"user", c.User},
+		{"tenant", c.Tenant},
+	}
+	for _, l := range limits {
+		if l.limit.RequestsPerSecond < 0 || l.limit.Burst < 0 || l.limit.MaxInFlight < 0 {
+			return fmt.Errorf("rateLimits: limits of %s must not be negative", l.scope)
+		}
+		if l.limit.Burst > 0 && l.limit.RequestsPerSecond == 0 {
+			return fmt.Errorf("rateLimits: burst of %s requires requestsPerSecond", l.scope)
+		}
+	}
+	return nil
+}
+
+func (c *LimitConfig) enabled() bool {
+	return c.RequestsPerSecond > 0 || c.MaxInFlight > 0
+}
+
+func (c *LimitConfig) burst() int {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	return int(math.Ceil(c.RequestsPerSecond))
+}
+
+// limiterSet limits the requests of each key (a user or a tenant).
+type limiterSet struct {
+	config  LimitConfig
+	mu      sync.Mutex
+	entries map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	inFlight int
+	lastUsed time.Time
+}
+
+// limitTicket is a request admitted by a limiterSet.
+type limitTicket struct {
+	set         *limiterSet
+	entry       *limiterEntry
+	reservation *rate.Reservation
+}
+
+func newLimiterSet(config LimitConfig) *limiterSet {
+	return &limiterSet{config: config, entries: map[string]*limiterEntry{}}
+}
+
+// acquire admits a request of a key. If the request is rejected, the returned duration is the time after which
+// the request can be retried. Admitted requests must be released.
+func (s *limiterSet) acquire(key string, now time.Time) (*limitTicket, time.Duration, string) {
+	if !s.config.enabled() {
+		return &limitTicket{}, 0, ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		limit := rate.Inf
+		if s.config.RequestsPerSecond > 0 {
+			limit = rate.Limit(s.config.RequestsPerSecond)
+		}
+		entry = &limiterEntry{limiter: rate.NewLimiter(limit, s.config.burst())}
+		s.entries[key] = entry
+	}
+	entry.lastUsed = now
+
+	if s.config.MaxInFlight > 0 && entry.inFlight >= s.config.MaxInFlight {
+		return nil, retryAfterInFlight, "concurrency"
+	}
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return nil, delay, "rate"
+	}
+
+	entry.inFlight++
+	return &limitTicket{set: s, entry: entry, reservation: reservation}, 0, ""
+}
+
+// release marks the request as completed.
+func (t *limitTicket) release() {
+	if t.set == nil {
+		return
+	}
+	t.set.mu.Lock()
+	defer t.set.mu.Unlock()
+	t.entry.inFlight--
+}
+
+// cancel releases a request which was not sent, and returns its token to the bucket.
+func (t *limitTicket) cancel() {
+	if t.set == nil {
+		return
+	}
+	t.reservation.Cancel()
+	t.release()
+}
+
+// cleanup removes the limiters of keys without recent requests.
+func (s *limiterSet) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, entry := range s.entries {
+		if entry.inFlight == 0 && now.Sub(entry.lastUsed) > rateLimiterIdleTimeout {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// rateLimiter limits the proxied requests per user and per tenant of a Tempo instance.
+type rateLimiter struct {
+	users   *limiterSet
+	tenants *limiterSet
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{users: newLimiterSet(config.User), tenants: newLimiterSet(config.Tenant)}
+}
+
+// acquire admits a request of a user to a tenant. If the request is rejected, it returns the scope of
+// the exceeded limit (user or tenant), the reason (rate or concurrency) and the time after which the request can be retried.
+func (l *rateLimiter) acquire(user string, tenant string) (release func(), retryAfter time.Duration, scope string, reason string) {
+	now := time.Now()
+	userTicket, retryAfter, reason := l.users.acquire(user, now)
+	if userTicket == nil {
+		return nil, retryAfter, "user", reason
+	}
+	tenantTicket, retryAfter, reason := l.tenants.acquire(tenant, now)
+	if tenantTicket == nil {
+		userTicket.cancel()
+		return nil, retryAfter, "tenant", reason
+	}
+	return func() {
+		userTicket.release()
+		tenantTicket.release()
+	}, 0, "", ""
+}
+
+func (l *rateLimiter) cleanup(ctx context.Context) {
+	now := time.Now()
+	l.users.cleanup(now)
+	l.tenants.cleanup(now)
+}
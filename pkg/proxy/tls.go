@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/metrics"
+	oscrypto "github.com/openshift/library-go/pkg/crypto"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// interval in which the CA bundles and client certificates of Tempo instances with cached proxies are reloaded
+const tlsReloadInterval = time.Minute
+
+// tlsMaterial contains the PEM encoded CA bundle and client certificate of a Tempo instance.
+type tlsMaterial struct {
+	caPEM   []byte
+	certPEM []byte
+	keyPEM  []byte
+	// caSource describes the origin of the CA bundle in error messages
+	caSource string
+}
+
+// fingerprint returns a hash of the TLS material, to detect changes.
+func (m tlsMaterial) fingerprint() string {
+	hash := sha256.New()
+	for _, data := range [][]byte{m.caPEM, m.certPEM, m.keyPEM} {
+		hash.Write(data)
+		// separator, to distinguish moving data between the fields
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// tlsConfigSource returns the TLS config of a Tempo resource.
+// Instances managed by the Tempo operator use the service CA bundle, unless overridden in the plugin configuration.
+func (h *ProxyHandler) tlsConfigSource(tempo api.TempoResource) (api.TLSConfig, error) {
+	if tempo.Kind == api.KindStaticDatasource {
+		ds, ok := h.tempoCache.GetStaticDatasource(tempo.Name)
+		if !ok {
+			return api.TLSConfig{}, fmt.Errorf("datasource '%s' not found", tempo.Name)
+		}
+		return ds.TLS, nil
+	}
+
+	if instance, ok := h.opts.instanceConfig(tempo.Namespace, tempo.Name); ok && instance.TLS != nil {
+		return *instance.TLS, nil
+	}
+	return api.TLSConfig{CAFile: h.opts.ServiceCAFile}, nil
+}
+
+// loadTLSMaterial reads the CA bundle and client certificate from files, ConfigMaps or Secrets.
+func (h *ProxyHandler) loadTLSMaterial(ctx context.Context, cfg api.TLSConfig) (tlsMaterial, error) {
+	var material tlsMaterial
+	var err error
+
+	switch {
+	case cfg.CAFile != "":
+		material.caPEM, err = os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("failed to read certificate file: tried '%s' and got %v", cfg.CAFile, err)
+		}
+		material.caSource = fmt.Sprintf("CA file %s", cfg.CAFile)
+	case cfg.CA != nil:
+		key := cfg.CA.Key
+		if key == "" {
+			key = "ca.crt"
+		}
+		material.caPEM, err = h.readObjectKey(ctx, cfg.CA.Kind, cfg.CA.Namespace, cfg.CA.Name, key)
+		if err != nil {
+			return tlsMaterial{}, err
+		}
+		material.caSource = fmt.Sprintf("key '%s' of %s %s/%s", key, cfg.CA.Kind, cfg.CA.Namespace, cfg.CA.Name)
+	}
+
+	switch {
+	case cfg.CertFile != "":
+		material.certPEM, err = os.ReadFile(cfg.CertFile)
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("cannot read client certificate: %w", err)
+		}
+		material.keyPEM, err = os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return tlsMaterial{}, fmt.Errorf("cannot read client key: %w", err)
+		}
+	case cfg.CertSecret != nil:
+		material.certPEM, err = h.readObjectKey(ctx, "Secret", cfg.CertSecret.Namespace, cfg.CertSecret.Name, corev1.TLSCertKey)
+		if err != nil {
+			return tlsMaterial{}, err
+		}
+		material.keyPEM, err = h.readObjectKey(ctx, "Secret", cfg.CertSecret.Namespace, cfg.CertSecret.Name, corev1.TLSPrivateKeyKey)
+		if err != nil {
+			return tlsMaterial{}, err
+		}
+	}
+
+	return material, nil
+}
+
+func (h *ProxyHandler) readObjectKey(ctx context.Context, kind string, namespace string, name string, key string) ([]byte, error) {
+	if h.k8sclient == nil {
+		return nil, fmt.Errorf("cannot read %s %s/%s: no Kubernetes client configured", kind, namespace, name)
+	}
+
+	var data []byte
+	var found bool
+	switch kind {
+	case "ConfigMap":
+		configMap, err := h.k8sclient.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ConfigMap %s/%s: %w", namespace, name, err)
+		}
+		var value string
+		value, found = configMap.Data[key]
+		data = []byte(value)
+	case "Secret":
+		secret, err := h.k8sclient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("cannot read Secret %s/%s: %w", namespace, name, err)
+		}
+		data, found = secret.Data[key]
+	default:
+		return nil, fmt.Errorf("kind '%s' is not supported", kind)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("key '%s' not found in %s %s/%s", key, kind, namespace, name)
+	}
+	return data, nil
+}
+
+// buildTLSConfigFromMaterial returns a TLS config which verifies the server certificate with the CA bundle
+// of the TLS material, or with the system CA bundle if the material does not contain a CA bundle.
+// The owner of the material is included in error messages.
+func (h *ProxyHandler) buildTLSConfigFromMaterial(owner string, material tlsMaterial) (*tls.Config, error) {
+	tlsConfig := oscrypto.SecureTLSConfig(&tls.Config{})
+
+	if len(material.caPEM) > 0 {
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(material.caPEM) {
+			return nil, fmt.Errorf("%s: %s contains no certificates", owner, material.caSource)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if len(material.certPEM) > 0 {
+		cert, err := tls.X509KeyPair(material.certPEM, material.keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid client certificate: %w", owner, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if h.opts.TLSMinVersion != 0 {
+		tlsConfig.MinVersion = h.opts.TLSMinVersion
+	}
+	if len(h.opts.TLSCipherSuites) > 0 {
+		tlsConfig.CipherSuites = h.opts.TLSCipherSuites
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsConfigFor loads the TLS material of a Tempo resource and remembers its fingerprint,
+// to evict the proxies of this instance when the material changes. The fingerprint is returned as well.
+func (h *ProxyHandler) tlsConfigFor(ctx context.Context, tempo api.TempoResource) (*tls.Config, string, error) {
+	source, err := h.tlsConfigSource(tempo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	material, err := h.loadTLSMaterial(ctx, source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tlsConfig, err := h.buildTLSConfigFromMaterial(tlsOwner(tempo), material)
+	if err != nil {
+		return nil, "", err
+	}
+
+	fingerprint := material.fingerprint()
+	h.tlsFingerprintsMu.Lock()
+	defer h.tlsFingerprintsMu.Unlock()
+	h.tlsFingerprints[instanceKey(tempo.Namespace, tempo.Name)] = fingerprint
+	return tlsConfig, fingerprint, nil
+}
+
+// Start reloads the CA bundles and client certificates of Tempo instances with cached proxies
+// until the context is cancelled. The proxies of an instance are evicted if its TLS material changed.
+// It also removes the rate limiters of inactive users and tenants.
+func (h *ProxyHandler) Start(ctx context.Context) {
+	go wait.UntilWithContext(ctx, h.reloadTLS, tlsReloadInterval)
+	go wait.UntilWithContext(ctx, h.rateLimiter.cleanup, rateLimiterIdleTimeout)
+}
+
+func (h *ProxyHandler) reloadTLS(ctx context.Context) {
+	// instances with cached proxies
+	instances := map[string]bool{}
+	for _, key := range h.proxyCache.Keys() {
+		namespace, rest, _ := strings.Cut(key, "/")
+		name, _, _ := strings.Cut(rest, "/")
+		instances[instanceKey(namespace, name)] = true
+	}
+
+	h.tlsFingerprintsMu.Lock()
+	fingerprints := map[string]string{}
+	for key, fingerprint := range h.tlsFingerprints {
+		if instances[key] {
+			fingerprints[key] = fingerprint
+		} else {
+			delete(h.tlsFingerprints, key)
+		}
+	}
+	h.tlsFingerprintsMu.Unlock()
+
+	for key, fingerprint := range fingerprints {
+		namespace, name, _ := strings.Cut(key, "/")
+		logger := log.WithFields(logrus.Fields{"namespace": namespace, "tempo": name})
+
+		tempo, found, err := h.tempoCache.GetTempoResource(ctx, namespace, name)
+		if err != nil || !found {
+			// the proxies of deleted instances are evicted by the change handler of the cache
+			continue
+		}
+
+		source, err := h.tlsConfigSource(tempo)
+		if err != nil {
+			logger.WithError(err).Warn("cannot reload TLS config")
+			continue
+		}
+		material, err := h.loadTLSMaterial(ctx, source)
+		if err != nil {
+			// keep the proxies with the last valid TLS material
+			logger.WithError(err).Warn("cannot reload TLS config")
+			continue
+		}
+
+		if material.fingerprint() != fingerprint {
+			logger.Info("CA bundle or client certificate changed, evicting proxies")
+			metrics.TLSCertificateReloadsTotal.WithLabelValues("proxy").Inc()
+			h.evictProxies(namespace, name)
+		}
+	}
+}
+
+// tlsOwner describes a Tempo resource in errors of its TLS config.
+func tlsOwner(tempo api.TempoResource) string {
+	if tempo.Kind == api.KindStaticDatasource {
+		return fmt.Sprintf("datasource %s", tempo.Name)
+	}
+	return fmt.Sprintf("Tempo instance %s/%s", tempo.Namespace, tempo.Name)
+}
+
+func instanceKey(namespace string, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/metrics"
+)
+
+const (
+	// traceCacheName identifies the cache in the Cache-Status header (RFC 9211)
+	traceCacheName       = "distributed-tracing-console-plugin"
+	traceCacheTTL        = 5 * time.Minute
+	traceCacheMaxEntries = 1024
+	traceCacheMaxBytes   = 64 << 20
+	// larger traces are not cached, to keep a few traces from evicting all other entries
+	traceCacheMaxEntrySize = 8 << 20
+	// traceCacheMinAge is the minimum age of the end of the time range of a lookup.
+	// Spans of traces which ended recently may still be ingested, therefore these responses are not cached.
+	traceCacheMinAge = 5 * time.Minute
+	// traceCacheShortTTL is the TTL of lookups without a time range, e.g. of the trace detail page.
+	// The trace can still be ingested, therefore it is only cached for a short time.
+	traceCacheShortTTL = 30 * time.Second
+)
+
+// traceCache caches the responses of trace-by-ID lookups, which do not change once a trace is complete.
+// The cache is bounded by the number of entries and the total size of the responses.
+type traceCache struct {
+	mu      sync.Mutex
+	entries *expirable.LRU[string, *cachedTrace]
+	size    atomic.Int64
+}
+
+type cachedTrace struct {
+	header http.Header
+	body   []byte
+	stored time.Time
+	ttl    time.Duration
+}
+
+func newTraceCache() *traceCache {
+	c := &traceCache{}
+	c.entries = expirable.NewLRU(traceCacheMaxEntries, func(_ string, entry *cachedTrace) {
+		c.size.Add(-int64(len(entry.body)))
+		metrics.TraceCacheSizeBytes.Set(float64(c.size.Load()))
+		metrics.TraceCacheEvictionsTotal.Inc()
+	}, traceCacheTTL)
+	return c
+}
+
+func (c *traceCache) get(key string) (*cachedTrace, bool) {
+	entry, ok := c.entries.Get(key)
+	if !ok {
+		return nil, false
+	}
+	// entries with a shorter TTL than the TTL of the cache expire on access
+	if time.Since(entry.stored) >= entry.ttl {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if current, ok := c.entries.Peek(key); ok && current == entry {
+			c.entries.Remove(key)
+		}
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *traceCache) add(key string, entry *cachedTrace) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// replacing an entry does not call the eviction callback
+	c.entries.Remove(key)
+	c.entries.Add(key, entry)
+	c.size.Add(int64(len(entry.body)))
+	for c.size.Load() > traceCacheMaxBytes {
+		if _, _, ok := c.entries.RemoveOldest(); !ok {
+			break
+		}
+	}
+	metrics.TraceCacheSizeBytes.Set(float64(c.size.Load()))
+}
+
+// removeInstance removes all cached traces of a Tempo instance.
+func (c *traceCache) removeInstance(namespace string, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := instanceKey(namespace, name) + "/"
+	for _, key := range c.entries.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.entries.Remove(key)
+		}
+	}
+}
+
+// traceLookupPaths are the trace-by-ID APIs of Tempo, by the path without the trace id.
+var traceLookupPaths = []string{"/api/traces/", "/api/v2/traces/"}
+
+// traceCacheKey returns the cache key and TTL of a trace-by-ID lookup. It returns false if the request is not a
+// trace-by-ID lookup, and an empty key if the response must not be cached.
+//
+// The scope identifies the credentials forwarded to Tempo, because the response can depend on them.
+func traceCacheKey(r *http.Request, apiPath string, instance string, tenant string, scope string) (string, time.Duration, bool) {
+	if r.Method != http.MethodGet {
+		return "", 0, false
+	}
+
+	var version, traceID string
+	for _, prefix := range traceLookupPaths {
+		if id, ok := strings.CutPrefix(apiPath, prefix); ok && id != "" && !strings.Contains(id, "/") {
+			version, traceID = prefix, id
+		}
+	}
+	if traceID == "" {
+		return "", 0, false
+	}
+	traceID, ok := normalizeTraceID(traceID)
+	if !ok {
+		// invalid trace ids are rejected by Tempo
+		return "", 0, true
+	}
+
+	query := r.URL.Query()
+	for _, param := range []string{"start", "end"} {
+		if query.Has(param) {
+			if _, err := strconv.ParseInt(query.Get(param), 10, 64); err != nil {
+				return "", 0, true
+			}
+		}
+	}
+	ttl := traceCacheShortTTL
+	if query.Has("end") {
+		end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+		if time.Unix(end, 0).After(time.Now().Add(-traceCacheMinAge)) {
+			return "", 0, true
+		}
+		ttl = traceCacheTTL
+	}
+
+	// the format and encoding of the response depend on these headers
+	accept := strings.ToLower(r.Header.Get("Accept"))
+	acceptEncoding := strings.ToLower(r.Header.Get("Accept-Encoding"))
+
+	// url.Values.Encode sorts the parameters by key
+	return fmt.Sprintf("%s/%s/%s\n%s%s?%s\n%s\n%s", instance, tenant, scope, version, traceID, query.Encode(), accept, acceptEncoding), ttl, true
+}
+
+// normalizeTraceID converts a hex trace id to the 32 character, lower case format used by Tempo.
+func normalizeTraceID(traceID string) (string, bool) {
+	if len(traceID) > 32 {
+		return "", false
+	}
+	for _, c := range traceID {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return "", false
+		}
+	}
+	return strings.Repeat("0", 32-len(traceID)) + strings.ToLower(traceID), true
+}
+
+// cacheStatus returns the value of the Cache-Status header (RFC 9211).
+func cacheStatus(params ...string) string {
+	return strings.Join(append([]string{traceCacheName}, params...), "; ")
+}
+
+// serveCachedTrace writes a cached response.
+func serveCachedTrace(w http.ResponseWriter, entry *cachedTrace) {
+	for name, values := range entry.header {
+		w.Header()[name] = values
+	}
+	age := time.Since(entry.stored)
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	w.Header().Set("Cache-Status", cacheStatus("hit", fmt.Sprintf("ttl=%d", int((entry.ttl-age).Seconds()))))
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+}
+
+// newCachedTrace returns the response of Tempo recorded with its body, or false if it cannot be cached.
+func newCachedTrace(recorder *metrics.StatusRecorder, ttl time.Duration) (*cachedTrace, bool) {
+	body, ok := recorder.Body()
+	if !ok || recorder.Status() != http.StatusOK {
+		return nil, false
+	}
+	cacheControl := strings.ToLower(recorder.Header().Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return nil, false
+	}
+
+	header := recorder.Header().Clone()
+	header.Del("Cache-Status")
+	header.Del("Date")
+	return &cachedTrace{header: header, body: body, stored: time.Now(), ttl: ttl}, true
+}
+
+// requestBypassesCache checks if the client requested a response from Tempo instead of the cache.
+func requestBypassesCache(r *http.Request) bool {
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store")
+}
+
+// userScope returns the cache scope of responses of requests forwarded with the token of a user.
+func userScope(user string) string {
+	return "user=" + url.QueryEscape(user)
+}
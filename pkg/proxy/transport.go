@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Defaults of TransportConfig.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	dialerKeepalive            = 30 * time.Second
+)
+
+// TransportConfig contains the connection settings of the proxy to the Tempo instances. Zero values use the defaults.
+type TransportConfig struct {
+	// MaxIdleConns limits the idle connections to all hosts of a transport. Defaults to 100.
+	MaxIdleConns int `json:"maxIdleConns,omitempty" yaml:"maxIdleConns,omitempty"`
+	// MaxIdleConnsPerHost limits the idle connections to a host. Defaults to 32.
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost,omitempty" yaml:"maxIdleConnsPerHost,omitempty"`
+	// MaxConnsPerHost limits the connections to a host, including connections in use. Unlimited by default.
+	MaxConnsPerHost int `json:"maxConnsPerHost,omitempty" yaml:"maxConnsPerHost,omitempty"`
+	// IdleConnTimeout is the time after which idle connections are closed. Defaults to 90s.
+	IdleConnTimeout time.Duration `json:"idleConnTimeout,omitempty" yaml:"idleConnTimeout,omitempty"`
+	// ResponseHeaderTimeout limits the time to wait for the response headers of Tempo.
+	// By default, only the request timeout of the plugin applies.
+	ResponseHeaderTimeout time.Duration `json:"responseHeaderTimeout,omitempty" yaml:"responseHeaderTimeout,omitempty"`
+	// DialTimeout limits the time to establish a connection. Defaults to 30s.
+	DialTimeout time.Duration `json:"dialTimeout,omitempty" yaml:"dialTimeout,omitempty"`
+	// TLSHandshakeTimeout limits the time of the TLS handshake. Defaults to 10s.
+	TLSHandshakeTimeout time.Duration `json:"tlsHandshakeTimeout,omitempty" yaml:"tlsHandshakeTimeout,omitempty"`
+	// DisableHTTP2 disables HTTP/2, which is used for TLS connections to servers supporting it.
+	DisableHTTP2 bool `json:"disableHTTP2,omitempty" yaml:"disableHTTP2,omitempty"`
+}
+
+// Validate checks that no limit or timeout is negative.
+func (c *TransportConfig) Validate() error {
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"maxIdleConns", c.MaxIdleConns},
+		{"maxIdleConnsPerHost", c.MaxIdleConnsPerHost},
+		{"maxConnsPerHost", c.MaxConnsPerHost},
+	}
+	for _, limit := range limits {
+		if limit.value < 0 {
+			return fmt.Errorf("transport: %s must not be negative, got %d", limit.name, limit.value)
+		}
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"idleConnTimeout", c.IdleConnTimeout},
+		{"responseHeaderTimeout", c.ResponseHeaderTimeout},
+		{"dialTimeout", c.DialTimeout},
+		{"tlsHandshakeTimeout", c.TLSHandshakeTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			return fmt.Errorf("transport: %s must not be negative, got %s", timeout.name, timeout.value)
+		}
+	}
+	return nil
+}
+
+func orDefault[T int | time.Duration](value T, defaultValue T) T {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// newTransport creates a transport to Tempo instances.
+func newTransport(config TransportConfig, tlsConfig *tls.Config) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   orDefault(config.DialTimeout, defaultDialTimeout),
+		KeepAlive: dialerKeepalive,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   orDefault(config.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		MaxIdleConns:          orDefault(config.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   orDefault(config.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       orDefault(config.IdleConnTimeout, defaultIdleConnTimeout),
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		// a custom dialer and TLS config disable HTTP/2, unless it is requested explicitly
+		ForceAttemptHTTP2: !config.DisableHTTP2,
+	}
+	if config.DisableHTTP2 {
+		// a non-nil, empty map disables HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// pooledTransport is a transport shared by all proxies to the same host with the same TLS material.
+type pooledTransport struct {
+	transport *http.Transport
+	// instances contains the Tempo instances (namespace/name) using the transport
+	instances map[string]bool
+}
+
+// transportFor returns the pooled transport to the host of a URL with the given TLS material,
+// to reuse the connections to the host. A rotated CA bundle or client certificate results in a new transport.
+func (h *ProxyHandler) transportFor(instance string, target *url.URL, tlsFingerprint string, tlsConfig *tls.Config) *http.Transport {
+	key := fmt.Sprintf("%s://%s#%s", target.Scheme, target.Host, tlsFingerprint)
+
+	h.transportsMu.Lock()
+	defer h.transportsMu.Unlock()
+	pooled, ok := h.transports[key]
+	if !ok {
+		pooled = &pooledTransport{transport: newTransport(h.opts.Transport, tlsConfig), instances: map[string]bool{}}
+		h.transports[key] = pooled
+	}
+	pooled.instances[instance] = true
+	return pooled.transport
+}
+
+// releaseTransports releases the transports used by a Tempo instance. Transports which are not used
+// by other instances are removed from the pool, and their idle connections are closed.
+// Requests in flight are not interrupted.
+func (h *ProxyHandler) releaseTransports(namespace string, name string) {
+	h.transportsMu.Lock()
+	defer h.transportsMu.Unlock()
+
+	instance := instanceKey(namespace, name)
+	for key, pooled := range h.transports {
+		delete(pooled.instances, instance)
+		if len(pooled.instances) == 0 {
+			pooled.transport.CloseIdleConnections()
+			delete(h.transports, key)
+		}
+	}
+}
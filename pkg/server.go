@@ -4,31 +4,44 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
+	"golang.org/x/sync/errgroup"
 	"k8s.io/apiserver/pkg/server/dynamiccertificates"
 	k8sapiflag "k8s.io/component-base/cli/flag"
 
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/openshift/distributed-tracing-console-plugin/pkg/api"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/auth"
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/metrics"
 	"github.com/openshift/distributed-tracing-console-plugin/pkg/proxy"
 )
 
 var log = logrus.WithField("module", "server")
 
 type Config struct {
-	Port             int
+	Port int
+	// MetricsPort serves /metrics on a separate port. If 0, /metrics is served on Port.
+	MetricsPort int
+	// ShutdownDelay is the time between failing the readiness checks and closing the listeners on shutdown.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout is the maximum time to wait for in-flight requests on shutdown.
+	ShutdownTimeout  time.Duration
 	CertFile         string
 	PrivateKeyFile   string
 	TLSMinVersion    string
@@ -41,6 +54,18 @@ type Config struct {
 
 type PluginConfig struct {
 	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// SingleTenantInstances enables listing and querying Tempo instances without multi-tenancy
+	SingleTenantInstances bool `json:"singleTenantInstances,omitempty" yaml:"singleTenantInstances,omitempty"`
+	// Instances contains settings for individual Tempo instances, they are not exposed to the frontend
+	Instances []proxy.InstanceConfig `json:"-" yaml:"instances,omitempty"`
+	// Datasources are Tempo instances not managed by the Tempo operator, they are listed alongside the Tempo CRs
+	Datasources []api.StaticDatasource `json:"-" yaml:"datasources,omitempty"`
+	// AllowedPaths overrides the APIs of Tempo which can be queried via the proxy
+	AllowedPaths []proxy.AllowedPath `json:"-" yaml:"allowedPaths,omitempty"`
+	// Transport contains the connection settings of the proxy to the Tempo instances
+	Transport proxy.TransportConfig `json:"-" yaml:"transport,omitempty"`
+	// RateLimits limits the proxied requests per user and per tenant
+	RateLimits proxy.RateLimitConfig `json:"-" yaml:"rateLimits,omitempty"`
 }
 
 func (pluginConfig *PluginConfig) MarshalJSON() ([]byte, error) {
@@ -54,61 +79,106 @@ func (pluginConfig *PluginConfig) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// Start runs the server until SIGTERM or SIGINT is received, and shuts it down gracefully.
 func Start(cfg *Config) {
-	k8sconfig, errInCluster := rest.InClusterConfig()
-	if errInCluster != nil {
-		// Try local kubeconfig file
-		var errKubeconfig error
-		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-		configOverrides := &clientcmd.ConfigOverrides{}
-		k8sconfig, errKubeconfig = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
-		if errKubeconfig != nil {
-			panic(fmt.Errorf("cannot get in-cluster config: %w or kubeconfig: %w", errInCluster, errKubeconfig))
-		}
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
-	k8sclient, err := dynamic.NewForConfig(k8sconfig)
-	if err != nil {
-		panic(fmt.Errorf("error creating dynamicClient: %w", err))
+	if err := Run(ctx, cfg); err != nil {
+		log.WithError(err).Fatal("server failed")
 	}
+}
 
-	router, pluginConfig := setupRoutes(cfg, k8sclient)
-	router.Use(corsHeaderMiddleware())
-
-	loggedRouter := handlers.LoggingHandler(log.Logger.Out, router)
-
+// Run runs the server until the context is cancelled.
+// On cancellation, the server is marked as not ready, and after cfg.ShutdownDelay the listeners are closed
+// and in-flight requests are drained for up to cfg.ShutdownTimeout. Requests which take longer are aborted.
+func Run(ctx context.Context, cfg *Config) error {
+	// static settings are validated before connecting to the cluster, to fail fast on configuration errors
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 	}
+	// the TLS settings also apply to the connections of the proxy to the Tempo instances
+	proxyOptions := proxy.Options{
+		ServiceCAFile: cfg.CertFile,
+	}
 
 	if cfg.TLSMinVersion != "" {
 		tlsVersion, err := k8sapiflag.TLSVersion(cfg.TLSMinVersion)
 		if err != nil {
-			logrus.WithError(err).Fatal("invalid TLS min version")
+			return fmt.Errorf("invalid TLS min version: %w", err)
 		}
 		tlsConfig.MinVersion = tlsVersion
+		proxyOptions.TLSMinVersion = tlsVersion
 	}
 
 	if len(cfg.TLSCipherSuites) > 0 {
 		cipherSuiteIDs, err := k8sapiflag.TLSCipherSuites(cfg.TLSCipherSuites)
 		if err != nil {
-			logrus.WithError(err).Fatal("invalid TLS cipher suites")
+			return fmt.Errorf("invalid TLS cipher suites: %w", err)
 		}
 		tlsConfig.CipherSuites = cipherSuiteIDs
+		proxyOptions.TLSCipherSuites = cipherSuiteIDs
 	}
 
-	timeout := 30 * time.Second
-	if pluginConfig != nil {
-		timeout = pluginConfig.Timeout
+	pluginConfig, err := newDynamicPluginConfig(cfg.PluginConfigPath)
+	if err != nil {
+		return err
+	}
+
+	// fail on startup instead of serving an unexpected manifest
+	features, err := resolveFeatures(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid features: %w", err)
+	}
+	manifest, err := loadManifest(cfg, features, pluginConfig.Config())
+	if err != nil {
+		return fmt.Errorf("invalid features: %w", err)
+	}
+
+	k8sconfig, err := loadKubeConfig()
+	if err != nil {
+		return err
+	}
+
+	k8sclient, err := dynamic.NewForConfig(k8sconfig)
+	if err != nil {
+		return fmt.Errorf("error creating dynamicClient: %w", err)
+	}
+
+	k8sclientset, err := kubernetes.NewForConfig(k8sconfig)
+	if err != nil {
+		return fmt.Errorf("error creating clientset: %w", err)
 	}
 
+	// the health checks must not block longer than the timeout of the probes
+	discoveryConfig := rest.CopyConfig(k8sconfig)
+	discoveryConfig.Timeout = healthCheckTimeout
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(discoveryConfig)
+	if err != nil {
+		return fmt.Errorf("error creating discovery client: %w", err)
+	}
+
+	// background workers (informers, certificate watchers) run until all requests are drained
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	pluginConfig.Run(workersCtx)
+
+	ready := &atomic.Bool{}
+	router := setupRoutes(workersCtx, cfg, manifest, pluginConfig, proxyOptions, k8sclient, k8sclientset, discoveryClient, ready)
+	router.Use(corsHeaderMiddleware())
+	router.Use(metrics.Middleware)
+
+	// the timeout is applied per request, to pick up changes of the plugin configuration
+	loggedRouter := timeoutMiddleware(pluginConfig, handlers.LoggingHandler(log.Logger.Out, router))
+
 	tlsEnabled := cfg.CertFile != "" && cfg.PrivateKeyFile != ""
 	if tlsEnabled {
 		// Build and run the controller which reloads the certificate and key
 		// files whenever they change.
 		certKeyPair, err := dynamiccertificates.NewDynamicServingContentFromFiles("serving-cert", cfg.CertFile, cfg.PrivateKeyFile)
 		if err != nil {
-			logrus.WithError(err).Fatal("unable to create TLS controller")
+			return fmt.Errorf("unable to create TLS controller: %w", err)
 		}
 		ctrl := dynamiccertificates.NewDynamicServingCertificateController(
 			tlsConfig,
@@ -120,7 +190,7 @@ func Start(cfg *Config) {
 
 		// Check that the cert and key files are valid.
 		if err := ctrl.RunOnce(); err != nil {
-			logrus.WithError(err).Fatal("invalid certificate/key files")
+			return fmt.Errorf("invalid certificate/key files: %w", err)
 		}
 
 		// Wire GetConfigForClient so every TLS handshake uses the dynamically
@@ -128,72 +198,164 @@ func Start(cfg *Config) {
 		tlsConfig.GetConfigForClient = ctrl.GetConfigForClient
 		// Notify the controller whenever the cert/key files change on disk.
 		certKeyPair.AddListener(ctrl)
+		certKeyPair.AddListener(certReloadListener{})
 
-		ctx := context.Background()
-		go ctrl.Run(1, ctx.Done())
+		go ctrl.Run(1, workersCtx.Done())
 		// Start the file watcher that detects cert rotation and notifies the controller.
-		go certKeyPair.Run(ctx, 1)
+		go certKeyPair.Run(workersCtx, 1)
 	}
 
-	httpServer := &http.Server{
-		Handler:      loggedRouter,
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		TLSConfig:    tlsConfig,
-		ReadTimeout:  timeout,
-		WriteTimeout: timeout,
+	servers := []*http.Server{{
+		Handler:           loggedRouter,
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: defaultTimeout,
+		IdleTimeout:       defaultTimeout,
+	}}
+	if cfg.MetricsPort != 0 {
+		servers = append(servers, &http.Server{
+			Handler:      metrics.Handler(),
+			Addr:         fmt.Sprintf(":%d", cfg.MetricsPort),
+			TLSConfig:    tlsConfig,
+			ReadTimeout:  defaultTimeout,
+			WriteTimeout: defaultTimeout,
+		})
 	}
 
-	if tlsEnabled {
-		log.Infof("listening on https://:%d", cfg.Port)
-		panic(httpServer.ListenAndServeTLS(cfg.CertFile, cfg.PrivateKeyFile))
-	} else {
-		log.Infof("listening on http://:%d", cfg.Port)
-		panic(httpServer.ListenAndServe())
+	serveErrs := make(chan error, len(servers))
+	for _, httpServer := range servers {
+		go func() {
+			var err error
+			if tlsEnabled {
+				log.Infof("listening on https://%s", httpServer.Addr)
+				err = httpServer.ListenAndServeTLS(cfg.CertFile, cfg.PrivateKeyFile)
+			} else {
+				log.Infof("listening on http://%s", httpServer.Addr)
+				err = httpServer.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				serveErrs <- err
+			}
+		}()
 	}
+	ready.Store(true)
+
+	select {
+	case err := <-serveErrs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Fail the readiness checks first, and keep serving requests until the endpoints
+	// of the service are updated, so that no new requests are routed to this instance.
+	log.Infof("shutting down, waiting %s before closing the listeners", cfg.ShutdownDelay)
+	ready.Store(false)
+	time.Sleep(cfg.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	g := errgroup.Group{}
+	for _, httpServer := range servers {
+		g.Go(func() error {
+			return httpServer.Shutdown(shutdownCtx)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("cannot drain in-flight requests: %w", err)
+		}
+		// long running requests, e.g. trace searches, must not fail the shutdown
+		log.WithError(err).Warnf("in-flight requests not completed within %s, closing connections", cfg.ShutdownTimeout)
+		for _, httpServer := range servers {
+			httpServer.Close()
+		}
+	}
+	log.Info("server stopped")
+	return nil
 }
 
-func setupRoutes(cfg *Config, k8sclient *dynamic.DynamicClient) (*mux.Router, *PluginConfig) {
-	configHandlerFunc, pluginConfig := configHandler(cfg)
+// loadKubeConfig returns the in-cluster config, or the config of the local kubeconfig file.
+func loadKubeConfig() (*rest.Config, error) {
+	k8sconfig, errInCluster := rest.InClusterConfig()
+	if errInCluster == nil {
+		return k8sconfig, nil
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	configOverrides := &clientcmd.ConfigOverrides{}
+	k8sconfig, errKubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
+	if errKubeconfig != nil {
+		return nil, fmt.Errorf("cannot get in-cluster config: %w or kubeconfig: %w", errInCluster, errKubeconfig)
+	}
+	return k8sconfig, nil
+}
+
+func setupRoutes(ctx context.Context, cfg *Config, manifest *pluginManifest, dynamicConfig *dynamicPluginConfig, proxyOptions proxy.Options, k8sclient *dynamic.DynamicClient, k8sclientset *kubernetes.Clientset, discoveryClient discovery.DiscoveryInterface, ready *atomic.Bool) *mux.Router {
+	// the Tempo cache and the proxy use the configuration loaded at startup
+	pluginConfig := dynamicConfig.Config()
+
+	tempoCacheOptions := api.TempoCacheOptions{}
+	if pluginConfig != nil {
+		tempoCacheOptions.SingleTenantInstances = pluginConfig.SingleTenantInstances
+		tempoCacheOptions.StaticDatasources = pluginConfig.Datasources
+	}
+	tempoCache := api.NewTempoCache(k8sclient, tempoCacheOptions)
+	tempoCache.Start(ctx)
+	authorizer := auth.NewAuthorizer(k8sclientset)
 
 	r := mux.NewRouter()
 
-	r.PathPrefix("/health").HandlerFunc(healthHandler())
+	// liveness checks must not depend on external services, to avoid restarting the plugin during an outage
+	livezHandler := healthHandler([]healthCheck{pingCheck()})
+	r.Path("/livez").HandlerFunc(livezHandler)
+	// kept for compatibility with existing probes
+	r.Path("/health").HandlerFunc(livezHandler)
+
+	readinessChecks := []healthCheck{
+		shutdownCheck(ready),
+		cachedCheck(kubernetesAPICheck(discoveryClient), healthCheckCacheTTL),
+		cachedCheck(tempoCRDCheck(discoveryClient), healthCheckCacheTTL),
+		manifestCheck(manifest.err),
+	}
+	if cfg.CertFile != "" && cfg.PrivateKeyFile != "" {
+		readinessChecks = append(readinessChecks, certificateCheck(cfg.CertFile))
+	}
+	r.Path("/readyz").HandlerFunc(healthHandler(readinessChecks))
 
-	// serve list of Tempo CRs found on the cluster
-	r.Path("/api/v1/list-tempo-resources").HandlerFunc(api.ListTempoResourcesHandler(k8sclient))
+	// the server port is reachable through the console proxy, therefore /metrics requires the same
+	// permission as behind kube-rbac-proxy. The separate metrics port is not exposed by the console.
+	if cfg.MetricsPort == 0 {
+		r.Path("/metrics").Handler(metricsHandler(authorizer, metrics.Handler()))
+	}
+
+	// serve list of Tempo CRs found on the cluster, filtered by the permissions of the user
+	r.Path("/api/v1/list-tempo-resources").HandlerFunc(api.ListTempoResourcesHandler(tempoCache, authorizer))
 
 	// uses the namespace and name to forward requests to a particular Tempo instance
-	var proxyTLSMinVersion uint16
-	if cfg.TLSMinVersion != "" {
-		var err error
-		proxyTLSMinVersion, err = k8sapiflag.TLSVersion(cfg.TLSMinVersion)
-		if err != nil {
-			logrus.WithError(err).Fatal("invalid TLS min version")
-		}
-	}
-	var proxyTLSCipherSuites []uint16
-	if len(cfg.TLSCipherSuites) > 0 {
-		var err error
-		proxyTLSCipherSuites, err = k8sapiflag.TLSCipherSuites(cfg.TLSCipherSuites)
-		if err != nil {
-			logrus.WithError(err).Fatal("invalid TLS cipher suites")
-		}
+	if pluginConfig != nil {
+		proxyOptions.Instances = pluginConfig.Instances
+		proxyOptions.AllowedPaths = pluginConfig.AllowedPaths
+		proxyOptions.Transport = pluginConfig.Transport
+		proxyOptions.RateLimits = pluginConfig.RateLimits
 	}
-	r.PathPrefix("/proxy/{namespace}/{name}/{tenant}").Handler(proxy.NewProxyHandler(k8sclient, cfg.CertFile, proxyTLSMinVersion, proxyTLSCipherSuites))
+	proxyHandler := proxy.NewProxyHandler(tempoCache, authorizer, k8sclientset, proxyOptions)
+	proxyHandler.Start(ctx)
+	r.PathPrefix("/proxy/{namespace}/{name}/{tenant}").Handler(proxyHandler)
 
 	// serve plugin manifest according to enabled features
-	r.Path("/plugin-manifest.json").Handler(manifestHandler(cfg))
+	r.Path("/plugin-manifest.json").Handler(manifestHandler(manifest))
 
-	// serve enabled features list to the front-end
-	r.PathPrefix("/features").HandlerFunc(featuresHandler(cfg))
+	// serve the status of the enabled features
+	r.PathPrefix("/features").HandlerFunc(featuresHandler(manifest))
 
 	// serve plugin configuration to the front-end
-	r.PathPrefix("/config").HandlerFunc(configHandlerFunc)
+	r.PathPrefix("/config").HandlerFunc(dynamicConfig.configHandler())
 
 	// serve front end files
 	r.PathPrefix("/").Handler(filesHandler(http.Dir(cfg.StaticPath)))
 
-	return r, pluginConfig
+	return r
 }
 
 func filesHandler(root http.FileSystem) http.Handler {
@@ -211,70 +373,50 @@ func filesHandler(root http.FileSystem) http.Handler {
 	})
 }
 
-func healthHandler() http.HandlerFunc {
+// metricsHandler only serves requests of users which are allowed to get the /metrics non-resource URL.
+func metricsHandler(authorizer *auth.Authorizer, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("ok"))
-	})
-}
-
-func corsHeaderMiddleware() func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			headers := w.Header()
-			headers.Set("Access-Control-Allow-Origin", "*")
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-func featuresHandler(cfg *Config) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		jsonFeatures, err := json.Marshal(cfg.Features)
+		user, err := authorizer.AuthenticateRequest(r.Context(), r)
+		if err != nil {
+			if errors.Is(err, auth.ErrUnauthenticated) {
+				api.WriteErrorResponse(w, http.StatusUnauthorized, api.ErrorTypeUnauthorized, err)
+			} else {
+				api.WriteErrorResponse(w, http.StatusInternalServerError, api.ErrorTypeInternal, err)
+			}
+			return
+		}
 
+		allowed, reason, err := authorizer.AuthorizeNonResource(r.Context(), user, auth.ReadMetricsAttributes())
 		if err != nil {
-			log.WithError(err).Errorf("cannot marshall, features were: %v", string(jsonFeatures))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.WriteErrorResponse(w, http.StatusInternalServerError, api.ErrorTypeInternal, err)
+			return
+		}
+		if !allowed {
+			err = fmt.Errorf("user %q cannot get /metrics", user.Name)
+			if reason != "" {
+				err = fmt.Errorf("%w: %s", err, reason)
+			}
+			api.WriteErrorResponse(w, http.StatusForbidden, api.ErrorTypeForbidden, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonFeatures)
+		next.ServeHTTP(w, r)
 	})
 }
 
-func configHandler(cfg *Config) (http.HandlerFunc, *PluginConfig) {
-	pluginConfData, err := os.ReadFile(cfg.PluginConfigPath)
-
-	if err != nil {
-		log.WithError(err).Warnf("cannot read config file, serving plugin with default configuration, tried %s", cfg.PluginConfigPath)
-
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte("{}"))
-		}), nil
-	}
-
-	var pluginConfig PluginConfig
-	err = yaml.Unmarshal(pluginConfData, &pluginConfig)
-
-	if err != nil {
-		log.WithError(err).Error("unable to unmarshall config data")
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, "unable to unmarshall config data", http.StatusInternalServerError)
-		}), nil
-	}
+// certReloadListener counts the reloads of the serving certificate.
+type certReloadListener struct{}
 
-	jsonPluginConfig, err := pluginConfig.MarshalJSON()
+func (certReloadListener) Enqueue() {
+	metrics.TLSCertificateReloadsTotal.WithLabelValues("serving").Inc()
+}
 
-	if err != nil {
-		log.WithError(err).Errorf("unable to marshall, config data: %v", pluginConfig)
+func corsHeaderMiddleware() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, "unable to marshall config data", http.StatusInternalServerError)
-		}), nil
+			headers := w.Header()
+			headers.Set("Access-Control-Allow-Origin", "*")
+			next.ServeHTTP(w, r)
+		})
 	}
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonPluginConfig)
-	}), &pluginConfig
 }
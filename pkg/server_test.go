@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"math/big"
@@ -18,11 +19,19 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/openshift/distributed-tracing-console-plugin/pkg/auth"
 )
 
 type httpClientConfig struct {
@@ -83,12 +92,185 @@ func TestServerRunning(t *testing.T) {
 		t.Fatalf("Failed: could not fetch features endpoint: %v", err)
 	}
 
+	// metrics on the server port require a token which is allowed to get /metrics
+	if _, err = getRequestResults(t, httpClient, serverURL+"/metrics"); err == nil {
+		t.Fatalf("Failed: Should have failed fetching metrics without a token")
+	}
+
 	// sanity check - make sure we cannot get to a bogus context path
 	if _, err = getRequestResults(t, httpClient, serverURL+"/badroot"); err == nil {
 		t.Fatalf("Failed: Should have failed going to /badroot")
 	}
 }
 
+func TestMetricsHandler(t *testing.T) {
+	k8sclient := fake.NewClientset()
+	k8sclient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status.Authenticated = review.Spec.Token != "invalid-token"
+		review.Status.User = authenticationv1.UserInfo{Username: review.Spec.Token}
+		return true, review, nil
+	})
+	k8sclient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == "prometheus" && attributes != nil && attributes.Path == "/metrics" && attributes.Verb == "get"
+		return true, review, nil
+	})
+	handler := metricsHandler(auth.NewAuthorizer(k8sclient), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{name: "allowed", token: "prometheus", code: http.StatusOK},
+		{name: "forbidden", token: "developer", code: http.StatusForbidden},
+		{name: "invalid token", token: "invalid-token", code: http.StatusUnauthorized},
+		{name: "no token", code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestServerGracefulShutdown(t *testing.T) {
+	// Run creates the Kubernetes clients, the API server does not need to be reachable
+	if _, err := loadKubeConfig(); err != nil {
+		t.Skipf("no Kubernetes config available: %v", err)
+	}
+
+	testPort, err := getFreePort(testHostname)
+	require.NoError(t, err)
+	serverURL := fmt.Sprintf("http://%s:%d", testHostname, testPort)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- Run(ctx, &Config{
+			Port:            testPort,
+			StaticPath:      t.TempDir(),
+			ShutdownDelay:   time.Second,
+			ShutdownTimeout: 5 * time.Second,
+		})
+	}()
+
+	httpClient, err := (&httpClientConfig{}).buildHTTPClient()
+	require.NoError(t, err)
+	checkHTTPReady(httpClient, serverURL+"/health")
+	_, err = getRequestResults(t, httpClient, serverURL+"/health")
+	require.NoError(t, err)
+
+	// the server fails the shutdown readiness check, but keeps serving requests during the shutdown delay
+	cancel()
+	require.Eventually(t, func() bool {
+		resp, err := httpClient.Get(serverURL + "/readyz?verbose")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		var health healthResponse
+		if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+			return false
+		}
+		return resp.StatusCode == http.StatusServiceUnavailable &&
+			slices.Contains(health.Checks, healthCheckResult{Name: "shutdown", Status: "failed", Error: "server is shutting down"})
+	}, time.Second, 50*time.Millisecond)
+	_, err = getRequestResults(t, httpClient, serverURL+"/health")
+	require.NoError(t, err)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	_, err = httpClient.Get(serverURL + "/health")
+	require.Error(t, err, "the listener must be closed after shutdown")
+}
+
+func TestServerLivez(t *testing.T) {
+	// Run creates the Kubernetes clients, the API server does not need to be reachable
+	if _, err := loadKubeConfig(); err != nil {
+		t.Skipf("no Kubernetes config available: %v", err)
+	}
+
+	testPort, err := getFreePort(testHostname)
+	require.NoError(t, err)
+	serverURL := fmt.Sprintf("http://%s:%d", testHostname, testPort)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- Run(ctx, &Config{
+			Port:            testPort,
+			StaticPath:      t.TempDir(),
+			ShutdownDelay:   time.Second,
+			ShutdownTimeout: 5 * time.Second,
+		})
+	}()
+
+	httpClient, err := (&httpClientConfig{}).buildHTTPClient()
+	require.NoError(t, err)
+	checkHTTPReady(httpClient, serverURL+"/livez")
+
+	body, err := getRequestResults(t, httpClient, serverURL+"/livez?verbose")
+	require.NoError(t, err)
+	var health healthResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &health))
+	require.Equal(t, []healthCheckResult{{Name: "ping", Status: "ok"}}, health.Checks)
+
+	// the liveness check does not fail during the shutdown, to avoid restarting the plugin
+	cancel()
+	require.Eventually(t, func() bool {
+		resp, err := httpClient.Get(serverURL + "/readyz?verbose")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		var health healthResponse
+		if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+			return false
+		}
+		return slices.Contains(health.Checks, healthCheckResult{Name: "shutdown", Status: "failed", Error: "server is shutting down"})
+	}, time.Second, 50*time.Millisecond)
+	_, err = getRequestResults(t, httpClient, serverURL+"/livez")
+	require.NoError(t, err)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestServerInvalidTLSSettings(t *testing.T) {
+	testPort, err := getFreePort(testHostname)
+	require.NoError(t, err)
+
+	err = Run(context.Background(), &Config{Port: testPort, StaticPath: t.TempDir(), TLSMinVersion: "VersionTLS99"})
+	require.ErrorContains(t, err, "invalid TLS min version")
+
+	err = Run(context.Background(), &Config{Port: testPort, StaticPath: t.TempDir(), TLSCipherSuites: []string{"invalid"}})
+	require.ErrorContains(t, err, "invalid TLS cipher suites")
+}
+
 func TestSecureServerRunning(t *testing.T) {
 	testPort, err := getFreePort(testHostname)
 	if err != nil {
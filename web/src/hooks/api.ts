@@ -9,8 +9,11 @@ export interface APIResponse<T> {
   data?: T;
 }
 
+/** tenant in the proxy path of single-tenant instances, must match api.SingleTenantPlaceholder of the backend */
+export const SINGLE_TENANT_PLACEHOLDER = 'single-tenant';
+
 export function getProxyURLFor(tempo: TempoInstance) {
   return `${BACKEND_URL}/proxy/${encodeURIComponent(tempo.namespace)}/${encodeURIComponent(
     tempo.name,
-  )}/${encodeURIComponent(tempo.tenant ?? 'single-tenant')}`;
+  )}/${encodeURIComponent(tempo.tenant ?? SINGLE_TENANT_PLACEHOLDER)}`;
 }
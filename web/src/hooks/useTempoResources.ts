@@ -7,7 +7,7 @@ import { useQuery } from '@tanstack/react-query';
  * This is different from TempoInstance, which represents a selected Tempo instance including the tenant.
  */
 export type TempoResource = {
-  kind: 'TempoStack' | 'TempoMonolithic';
+  kind: 'TempoStack' | 'TempoMonolithic' | 'StaticDatasource';
   namespace: string;
   name: string;
   /** list of tenants for multi-tenant instances, undefined for single-tenant instances */